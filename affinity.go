@@ -0,0 +1,102 @@
+package flyrpc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// CodeAffinity is the reserved code of affinity handshake. Client send the
+// token it holds (or empty string) and server reply with the token to keep.
+const CodeAffinity = "$affinity"
+
+// AffinityToken tell which node holds the session state of a client.
+type AffinityToken struct {
+	Node     string `json:"n"`
+	ClientId int    `json:"c"`
+	Issued   int64  `json:"t"`
+}
+
+// AffinitySigner sign and verify affinity tokens with a secret shared by
+// all gateway nodes.
+type AffinitySigner struct {
+	secret []byte
+	// MaxAge of token, zero means never expire.
+	MaxAge time.Duration
+}
+
+func NewAffinitySigner(secret []byte) *AffinitySigner {
+	return &AffinitySigner{secret: secret}
+}
+
+func (s *AffinitySigner) sum(data string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Sign encode token as "payload.signature".
+func (s *AffinitySigner) Sign(token *AffinityToken) (string, error) {
+	bytes, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+	data := base64.RawURLEncoding.EncodeToString(bytes)
+	return data + "." + base64.RawURLEncoding.EncodeToString(s.sum(data)), nil
+}
+
+// Verify decode token, gateways can use it to find the owning node.
+func (s *AffinitySigner) Verify(str string) (*AffinityToken, error) {
+	parts := strings.Split(str, ".")
+	if len(parts) != 2 {
+		return nil, newError(ErrInvalidToken)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, s.sum(parts[0])) {
+		return nil, newError(ErrInvalidToken)
+	}
+	bytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, newError(ErrInvalidToken)
+	}
+	token := &AffinityToken{}
+	if err := json.Unmarshal(bytes, token); err != nil {
+		return nil, newFlyError(ErrInvalidToken, err)
+	}
+	if s.MaxAge > 0 && time.Since(time.Unix(token.Issued, 0)) > s.MaxAge {
+		return nil, newError(ErrInvalidToken)
+	}
+	return token, nil
+}
+
+// handleAffinity keep a valid token presented by client, or issue a new one
+// owned by this node.
+func (s *Server) handleAffinity(ctx *Context, presented string) (string, error) {
+	if presented != "" {
+		token, err := s.affinitySigner.Verify(presented)
+		if err == nil {
+			ctx.Affinity = token
+			if token.Node != s.nodeId {
+				for _, handler := range s.affinityHandlers {
+					handler(ctx, token)
+				}
+			}
+			return presented, nil
+		}
+		ctx.debug("Invalid affinity token", err)
+	}
+	token := &AffinityToken{
+		Node:     s.nodeId,
+		ClientId: ctx.ClientId,
+		Issued:   time.Now().Unix(),
+	}
+	signed, err := s.affinitySigner.Sign(token)
+	if err != nil {
+		return "", err
+	}
+	ctx.Affinity = token
+	return signed, nil
+}
@@ -0,0 +1,88 @@
+package flyrpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAffinitySigner(t *testing.T) {
+	s := NewAffinitySigner([]byte("secret"))
+	str, err := s.Sign(&AffinityToken{Node: "a", ClientId: 1, Issued: time.Now().Unix()})
+	assert.Nil(t, err)
+	token, err := s.Verify(str)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", token.Node)
+	assert.Equal(t, 1, token.ClientId)
+
+	_, err = NewAffinitySigner([]byte("other")).Verify(str)
+	assert.Error(t, err)
+	assert.Equal(t, ErrInvalidToken, err.Error())
+	_, err = s.Verify("x" + str)
+	assert.Error(t, err)
+
+	s.MaxAge = time.Minute
+	str, err = s.Sign(&AffinityToken{Node: "a", Issued: time.Now().Add(-time.Hour).Unix()})
+	assert.Nil(t, err)
+	_, err = s.Verify(str)
+	assert.Error(t, err)
+}
+
+func TestServerAffinity(t *testing.T) {
+	signer := NewAffinitySigner([]byte("secret"))
+	server := NewServer(&ServerOpts{
+		Serializer:     JSON,
+		NodeId:         "a",
+		AffinitySigner: signer,
+	})
+	moved := make(chan *AffinityToken, 1)
+	server.OnAffinity(func(ctx *Context, token *AffinityToken) {
+		moved <- token
+	})
+	go func() {
+		err := server.Listen("tcp", "127.0.0.1:15557")
+		assert.Nil(t, err)
+	}()
+	<-time.After(10 * time.Millisecond)
+
+	client := makeClient(t, "127.0.0.1:15557")
+	str, err := client.RequestAffinity("")
+	assert.Nil(t, err)
+	assert.Equal(t, str, client.AffinityToken())
+	token, err := signer.Verify(str)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", token.Node)
+
+	// valid token of this node is kept
+	reply, err := client.RequestAffinity(str)
+	assert.Nil(t, err)
+	assert.Equal(t, str, reply)
+
+	// token of another node is kept and reported
+	other, err := signer.Sign(&AffinityToken{Node: "b", ClientId: 7})
+	assert.Nil(t, err)
+	reply, err = client.RequestAffinity(other)
+	assert.Nil(t, err)
+	assert.Equal(t, other, reply)
+	token = <-moved
+	assert.Equal(t, "b", token.Node)
+	assert.Equal(t, 7, token.ClientId)
+
+	// token is presented on reconnect
+	assert.Nil(t, client.Connect("tcp", "127.0.0.1:15557"))
+	token = <-moved
+	assert.Equal(t, 7, token.ClientId)
+	assert.Equal(t, other, client.AffinityToken())
+
+	// node without affinity is tolerated
+	plain := NewServer(&ServerOpts{Serializer: JSON})
+	go plain.Listen("tcp", "127.0.0.1:15562")
+	defer plain.Close()
+	<-time.After(10 * time.Millisecond)
+	assert.Nil(t, client.Connect("tcp", "127.0.0.1:15562"))
+	assert.Equal(t, ClientConnected, client.State())
+	assert.Equal(t, other, client.AffinityToken())
+
+	server.Close()
+}
@@ -0,0 +1,194 @@
+package flyrpc
+
+import (
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultAuditQueueSize = 64
+
+// AuditRecord describe a handled request.
+type AuditRecord struct {
+	Time         time.Time
+	Code         string
+	ClientId     int
+	Identity     string
+	RequestSize  int
+	ResponseSize int
+	Duration     time.Duration
+	// Outcome is the error code replied, empty means success.
+	Outcome string
+}
+
+func (r *AuditRecord) setError(err error) {
+	if r != nil {
+		r.Outcome = err.Error()
+	}
+}
+
+func (r *AuditRecord) setResponse(size int) {
+	if r != nil {
+		r.ResponseSize = size
+	}
+}
+
+// AuditSink receive batches of audit records.
+type AuditSink interface {
+	Audit([]*AuditRecord) error
+}
+
+type AuditOpts struct {
+	Sink AuditSink
+	// BatchSize is the count of records delivered at once, default 1.
+	BatchSize int
+	// FlushInterval flush incomplete batch periodically, zero means only
+	// flush when batch is full or on Close.
+	FlushInterval time.Duration
+	// SampleRate in (0, 1] audit part of requests, zero means all.
+	SampleRate float64
+	// AlwaysCodes are audited regardless of SampleRate.
+	AlwaysCodes []string
+	// QueueSize is the count of batches waiting for Sink, default 64. Sink is
+	// called by a background goroutine, so a slow sink never blocks
+	// requests. Batches are dropped and counted by Dropped when queue is
+	// full.
+	QueueSize int
+}
+
+// Auditor sample and batch records for AuditSink.
+type Auditor struct {
+	sink          AuditSink
+	batchSize     int
+	sampleRate    float64
+	always        map[string]bool
+	lock          sync.Mutex
+	batch         []*AuditRecord
+	closed        bool
+	queue         chan []*AuditRecord
+	done          chan struct{}
+	dropped       int64
+	closeChan     chan struct{}
+	closeOnce     sync.Once
+	flushInterval time.Duration
+}
+
+func NewAuditor(opts *AuditOpts) *Auditor {
+	if opts.Sink == nil {
+		panic("require audit sink")
+	}
+	a := &Auditor{
+		sink:          opts.Sink,
+		batchSize:     opts.BatchSize,
+		sampleRate:    opts.SampleRate,
+		always:        make(map[string]bool),
+		closeChan:     make(chan struct{}),
+		flushInterval: opts.FlushInterval,
+	}
+	if a.batchSize <= 0 {
+		a.batchSize = 1
+	}
+	queueSize := opts.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultAuditQueueSize
+	}
+	a.queue = make(chan []*AuditRecord, queueSize)
+	a.done = make(chan struct{})
+	go a.run()
+	for _, code := range opts.AlwaysCodes {
+		a.always[code] = true
+	}
+	if a.flushInterval > 0 {
+		go a.flushLoop()
+	}
+	return a
+}
+
+func (a *Auditor) sampled(code string) bool {
+	if a.sampleRate <= 0 || a.sampleRate >= 1 || a.always[code] {
+		return true
+	}
+	return rand.Float64() < a.sampleRate
+}
+
+// Record add rec to batch if it is sampled.
+func (a *Auditor) Record(rec *AuditRecord) {
+	if !a.sampled(rec.Code) {
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.closed {
+		return
+	}
+	a.batch = append(a.batch, rec)
+	if len(a.batch) >= a.batchSize {
+		a.enqueue(a.batch)
+		a.batch = nil
+	}
+}
+
+// Flush hand the incomplete batch to Sink.
+func (a *Auditor) Flush() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if !a.closed && len(a.batch) > 0 {
+		a.enqueue(a.batch)
+		a.batch = nil
+	}
+}
+
+// Dropped returns the count of records dropped because queue is full.
+func (a *Auditor) Dropped() int64 {
+	return atomic.LoadInt64(&a.dropped)
+}
+
+// Close stop periodic flush, and wait for remaining records delivered.
+func (a *Auditor) Close() {
+	a.closeOnce.Do(func() {
+		close(a.closeChan)
+		a.lock.Lock()
+		a.closed = true
+		batch := a.batch
+		a.batch = nil
+		a.lock.Unlock()
+		if len(batch) > 0 {
+			a.queue <- batch
+		}
+		close(a.queue)
+		<-a.done
+	})
+}
+
+// enqueue is called with lock held.
+func (a *Auditor) enqueue(batch []*AuditRecord) {
+	select {
+	case a.queue <- batch:
+	default:
+		atomic.AddInt64(&a.dropped, int64(len(batch)))
+	}
+}
+
+func (a *Auditor) run() {
+	defer close(a.done)
+	for batch := range a.queue {
+		if err := a.sink.Audit(batch); err != nil {
+			log.Println("Audit error", len(batch), err)
+		}
+	}
+}
+
+func (a *Auditor) flushLoop() {
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.Flush()
+		case <-a.closeChan:
+			return
+		}
+	}
+}
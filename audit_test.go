@@ -0,0 +1,127 @@
+package flyrpc
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testAuditSink struct {
+	lock    sync.Mutex
+	batches [][]*AuditRecord
+}
+
+func (s *testAuditSink) Audit(batch []*AuditRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.batches = append(s.batches, batch)
+	return nil
+}
+
+func (s *testAuditSink) len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.batches)
+}
+
+// waitBatches wait for batches delivered by background goroutine.
+func (s *testAuditSink) waitBatches(n int) {
+	for s.len() < n {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+type blockAuditSink struct {
+	gate chan struct{}
+}
+
+func (s *blockAuditSink) Audit(batch []*AuditRecord) error {
+	<-s.gate
+	return nil
+}
+
+func TestRouterAudit(t *testing.T) {
+	s := JSON
+	payload, err := s.Marshal(&TestUser{Id: 123, Name: "abc"})
+	assert.Nil(t, err)
+	r := NewRouter(s)
+	sink := &testAuditSink{}
+	auditor := NewAuditor(&AuditOpts{Sink: sink})
+	r.SetAuditor(auditor)
+	protocol := NewMockProtocol()
+	ctx := NewContext(protocol, r, 5, s)
+	ctx.Identity = "user1"
+
+	r.AddRoute("pay", func(u *TestUser) *TestUser {
+		return u
+	})
+	r.AddRoute("fail", func(u *TestUser) error {
+		return newError("DENIED")
+	})
+	for _, code := range []string{"pay", "fail", "none"} {
+		err = r.emitPacket(ctx, &Packet{
+			Flag:    FlagWaitResponse,
+			Code:    code,
+			Payload: payload,
+		})
+		assert.Nil(t, err)
+	}
+
+	// payload discarded by protocol
+	r.AddRouteOpts("big", func(u *TestUser) {}, &RouteOpts{MaxRequestSize: 10})
+	assert.Nil(t, r.emitPacket(ctx, &Packet{Code: "big", Length: 1000}))
+
+	auditor.Close()
+	assert.Equal(t, 4, len(sink.batches))
+	rec := sink.batches[0][0]
+	assert.Equal(t, "pay", rec.Code)
+	assert.Equal(t, 5, rec.ClientId)
+	assert.Equal(t, "user1", rec.Identity)
+	assert.Equal(t, len(payload), rec.RequestSize)
+	assert.Equal(t, len(payload), rec.ResponseSize)
+	assert.Equal(t, "", rec.Outcome)
+	assert.Equal(t, "DENIED", sink.batches[1][0].Outcome)
+	assert.Equal(t, ErrNotFound, sink.batches[2][0].Outcome)
+	assert.Equal(t, 1000, sink.batches[3][0].RequestSize)
+	assert.Equal(t, ErrPayloadTooLarge, sink.batches[3][0].Outcome)
+}
+
+func TestAuditorBatchSample(t *testing.T) {
+	sink := &testAuditSink{}
+	a := NewAuditor(&AuditOpts{
+		Sink:        sink,
+		BatchSize:   2,
+		SampleRate:  0.000001,
+		AlwaysCodes: []string{"pay"},
+	})
+	a.Record(&AuditRecord{Code: "pay"})
+	a.Record(&AuditRecord{Code: "pay"})
+	sink.waitBatches(1)
+	assert.Equal(t, 2, len(sink.batches[0]))
+
+	for i := 0; i < 10; i++ {
+		a.Record(&AuditRecord{Code: "chat"})
+	}
+	a.Record(&AuditRecord{Code: "pay"})
+	a.Close()
+	assert.Equal(t, 2, len(sink.batches))
+	assert.Equal(t, 1, len(sink.batches[1]))
+}
+
+func TestAuditorSlowSink(t *testing.T) {
+	sink := &blockAuditSink{gate: make(chan struct{})}
+	a := NewAuditor(&AuditOpts{Sink: sink, QueueSize: 2})
+	// one batch is blocked in sink, two are queued
+	a.Record(&AuditRecord{Code: "pay"})
+	for len(a.queue) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 9; i++ {
+		a.Record(&AuditRecord{Code: "pay"})
+	}
+	assert.Equal(t, int64(7), a.Dropped())
+	close(sink.gate)
+	a.Close()
+}
@@ -0,0 +1,191 @@
+package flyrpc
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxCaptures    = 4
+	defaultCaptureCount   = 100
+	maxCaptureCount       = 10000
+	defaultCapturePayload = 256
+)
+
+// CapturedPacket is a packet recorded by debug capture.
+type CapturedPacket struct {
+	Time    time.Time
+	Inbound bool
+	Flag    byte
+	Seq     TSeq
+	Code    string
+	Length  int
+	// Payload is truncated to CaptureOpts.MaxPayload
+	Payload []byte
+}
+
+type CaptureOpts struct {
+	// Count of packets to capture, default 100.
+	Count int
+	// Duration keep capturing until timeout, only the latest Count packets
+	// are kept. Zero means stop after Count packets.
+	Duration time.Duration
+	// MaxPayload bytes kept of each packet, default 256.
+	MaxPayload int
+}
+
+// capture is a ring buffer of packets on one connection.
+type capture struct {
+	lock       sync.Mutex
+	ring       []CapturedPacket
+	next       int
+	total      int
+	deadline   time.Time
+	maxPayload int
+	stopped    bool
+}
+
+func newCapture(opts *CaptureOpts) *capture {
+	count := opts.Count
+	if count <= 0 {
+		count = defaultCaptureCount
+	} else if count > maxCaptureCount {
+		count = maxCaptureCount
+	}
+	c := &capture{
+		ring:       make([]CapturedPacket, 0, count),
+		maxPayload: opts.MaxPayload,
+	}
+	if c.maxPayload <= 0 {
+		c.maxPayload = defaultCapturePayload
+	}
+	if opts.Duration > 0 {
+		c.deadline = time.Now().Add(opts.Duration)
+	}
+	return c
+}
+
+func (c *capture) active() bool {
+	if c.stopped {
+		return false
+	}
+	if c.deadline.IsZero() {
+		return c.total < cap(c.ring)
+	}
+	return time.Now().Before(c.deadline)
+}
+
+func (c *capture) isActive() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.active()
+}
+
+func (c *capture) record(inbound bool, pkt *Packet) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.active() {
+		return
+	}
+	payload := pkt.Payload
+	if len(payload) > c.maxPayload {
+		payload = payload[:c.maxPayload]
+	}
+	cp := CapturedPacket{
+		Time:    time.Now(),
+		Inbound: inbound,
+		Flag:    pkt.Flag,
+		Seq:     pkt.Seq,
+		Code:    pkt.Code,
+		Length:  len(pkt.Payload),
+		Payload: append([]byte(nil), payload...),
+	}
+	if len(c.ring) < cap(c.ring) {
+		c.ring = append(c.ring, cp)
+	} else {
+		c.ring[c.next] = cp
+	}
+	c.next = (c.next + 1) % cap(c.ring)
+	c.total++
+}
+
+// packets returns captured packets, oldest first.
+func (c *capture) packets() []CapturedPacket {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	packets := make([]CapturedPacket, 0, len(c.ring))
+	if len(c.ring) == cap(c.ring) {
+		packets = append(packets, c.ring[c.next:]...)
+		packets = append(packets, c.ring[:c.next]...)
+	} else {
+		packets = append(packets, c.ring...)
+	}
+	return packets
+}
+
+func (c *capture) stop() {
+	c.lock.Lock()
+	c.stopped = true
+	c.lock.Unlock()
+}
+
+// StartCapture record packets of clientId's connection, so issues of one
+// client can be diagnosed without global debug log. It is intended to be
+// called by admin API.
+func (s *Server) StartCapture(clientId int, opts *CaptureOpts) error {
+	if opts == nil {
+		opts = &CaptureOpts{}
+	}
+	ctx := s.GetContext(clientId)
+	if ctx == nil {
+		return newError(ErrClientClosed)
+	}
+	s.captureLock.Lock()
+	defer s.captureLock.Unlock()
+	// finished captures are retained until StopCapture, count them too
+	retained := len(s.captures)
+	if s.captures[clientId] != nil {
+		retained--
+	}
+	if retained >= s.maxCaptures {
+		return newError(ErrTooManyCaptures)
+	}
+	if old := s.captures[clientId]; old != nil {
+		old.stop()
+	}
+	c := newCapture(opts)
+	s.captures[clientId] = c
+	ctx.capture.Store(c)
+	return nil
+}
+
+func (ctx *Context) recordCapture(inbound bool, pkt *Packet) {
+	if c, ok := ctx.capture.Load().(*capture); ok {
+		c.record(inbound, pkt)
+	}
+}
+
+// Capture returns packets captured of clientId, they are kept after the
+// client disconnected until StopCapture.
+func (s *Server) Capture(clientId int) ([]CapturedPacket, error) {
+	s.captureLock.Lock()
+	c := s.captures[clientId]
+	s.captureLock.Unlock()
+	if c == nil {
+		return nil, newError(ErrNotFound)
+	}
+	return c.packets(), nil
+}
+
+// StopCapture stop capturing and release captured packets.
+func (s *Server) StopCapture(clientId int) []CapturedPacket {
+	s.captureLock.Lock()
+	c := s.captures[clientId]
+	delete(s.captures, clientId)
+	s.captureLock.Unlock()
+	if c == nil {
+		return nil
+	}
+	c.stop()
+	return c.packets()
+}
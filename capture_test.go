@@ -0,0 +1,67 @@
+package flyrpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCaptureRing(t *testing.T) {
+	c := newCapture(&CaptureOpts{Count: 2, Duration: time.Minute, MaxPayload: 2})
+	for _, code := range []string{"1", "2", "3"} {
+		c.record(true, &Packet{Code: code, Payload: []byte("abc")})
+	}
+	packets := c.packets()
+	assert.Equal(t, 2, len(packets))
+	assert.Equal(t, "2", packets[0].Code)
+	assert.Equal(t, "3", packets[1].Code)
+	assert.Equal(t, 3, packets[1].Length)
+	assert.Equal(t, "ab", string(packets[1].Payload))
+
+	// stop after count if no duration
+	c = newCapture(&CaptureOpts{Count: 1})
+	c.record(true, &Packet{Code: "1"})
+	c.record(true, &Packet{Code: "2"})
+	assert.Equal(t, false, c.isActive())
+	assert.Equal(t, "1", c.packets()[0].Code)
+}
+
+func TestServerCapture(t *testing.T) {
+	server := NewServer(&ServerOpts{
+		Serializer:  JSON,
+		MaxCaptures: 1,
+	})
+	server.OnMessage("echo", func(name string) string {
+		return name
+	})
+	protocol := NewMockProtocol()
+	ctx := NewContext(protocol, server.Router, 1, JSON)
+	server.contextMap[1] = ctx
+	server.contextMap[2] = NewContext(NewMockProtocol(), server.Router, 2, JSON)
+
+	assert.Error(t, server.StartCapture(3, nil))
+	assert.Nil(t, server.StartCapture(1, &CaptureOpts{Count: 10}))
+	err := server.StartCapture(2, nil)
+	assert.Error(t, err)
+	assert.Equal(t, ErrTooManyCaptures, err.Error())
+
+	ctx.emitPacket(&Packet{Flag: FlagWaitResponse, Code: "echo", Seq: 1, Payload: []byte("hi")})
+	server.captures[1].stop()
+	// finished capture is retained until StopCapture
+	err = server.StartCapture(2, nil)
+	assert.Error(t, err)
+	assert.Equal(t, ErrTooManyCaptures, err.Error())
+	packets, err := server.Capture(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(packets))
+	assert.True(t, packets[0].Inbound)
+	assert.Equal(t, "echo", packets[0].Code)
+	assert.Equal(t, false, packets[1].Inbound)
+	assert.Equal(t, "hi", string(packets[1].Payload))
+
+	assert.Equal(t, 2, len(server.StopCapture(1)))
+	_, err = server.Capture(1)
+	assert.Error(t, err)
+	assert.Nil(t, server.StartCapture(2, nil))
+}
@@ -4,71 +4,404 @@ import (
 	"io"
 	"log"
 	"net"
+	"sync"
+	"time"
 )
 
-// Client use to connect server.
+// ClientState is the connection state of Client.
+type ClientState int
+
+const (
+	// ClientIdle is not connected yet.
+	ClientIdle ClientState = iota
+	ClientConnected
+	// ClientClosed is closed by Close or connection lost, it can Connect
+	// again.
+	ClientClosed
+)
+
+func (s ClientState) String() string {
+	switch s {
+	case ClientIdle:
+		return "idle"
+	case ClientConnected:
+		return "connected"
+	case ClientClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+type ClientOpts struct {
+	Serializer Serializer
+	// Timeout of calls, zero means default.
+	Timeout time.Duration
+	// Framing of connections, optional.
+	Framing *FramingProfile
+}
+
+// Client use to connect server. Handlers added by OnMessage and the outbox
+// are kept across connections.
 type Client struct {
-	// extend with *Context
-	*Context
+	Router        Router
+	serializer    Serializer
+	timeout       time.Duration
+	framing       *FramingProfile
+	lock          sync.Mutex
+	state         ClientState
+	context       *Context
+	closeHandlers []func(*Client)
+	affinityToken string
+	outbox        *outbox
+}
+
+func NewClient(opts *ClientOpts) *Client {
+	if opts == nil {
+		opts = &ClientOpts{}
+	}
+	serializer := opts.Serializer
+	if serializer == nil {
+		serializer = JSON
+	}
+	return &Client{
+		Router:     NewRouter(serializer),
+		serializer: serializer,
+		timeout:    opts.Timeout,
+		framing:    opts.Framing,
+	}
 }
 
 func Dial(network, address string) (*Client, error) {
-	conn, err := net.Dial(network, address)
-	if err != nil {
+	return DialFraming(network, address, nil)
+}
+
+// DialFraming connect server listening with framing profile.
+func DialFraming(network, address string, framing *FramingProfile) (*Client, error) {
+	c := NewClient(&ClientOpts{Framing: framing})
+	if err := c.Connect(network, address); err != nil {
 		return nil, err
 	}
-	var protocol Protocol
-	if network == "tcp" || network == "unix" {
-		protocol = NewTcpProtocol(conn, false)
-	} else {
-		return nil, newError("not support protocol " + network)
-	}
-	return newClient(protocol, nil), nil
+	return c, nil
 }
 
-func newTcpClient(conn net.Conn, serializer Serializer) *Client {
-	protocol := NewTcpProtocol(conn, false)
-	return newClient(protocol, serializer)
+// NewClientConn create client on an established connection, e.g. TLS.
+// framing is optional.
+func NewClientConn(conn net.Conn, framing *FramingProfile) (*Client, error) {
+	c := NewClient(&ClientOpts{Framing: framing})
+	if err := c.ConnectConn(conn); err != nil {
+		return nil, err
+	}
+	return c, nil
 }
 
-// Create new Client instance.
+// newClient create client on protocol, used by tests.
 func newClient(protocol Protocol, serializer Serializer) *Client {
-	if serializer == nil {
-		serializer = JSON
+	c := NewClient(&ClientOpts{Serializer: serializer})
+	c.attach(protocol)
+	return c
+}
+
+// Connect server, the previous connection is closed.
+func (c *Client) Connect(network, address string) error {
+	if network != "tcp" && network != "unix" {
+		return newError("not support protocol " + network)
+	}
+	if c.framing != nil {
+		if err := c.framing.Validate(); err != nil {
+			return err
+		}
 	}
-	router := NewRouter(serializer)
-	context := NewContext(protocol, router, 99, serializer)
-	cli := &Client{
-		context,
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return err
 	}
-	go cli.handlePackets()
-	return cli
+	return c.ConnectConn(conn)
 }
 
-func (c *Client) SetSerializer(serializer Serializer) {
-	c.serializer = serializer
-	c.Router.(*router).serializer = serializer
+// ConnectConn use an established connection, e.g. TLS. The affinity token
+// got from previous connection is presented, the connection is closed if
+// the handshake failed. Servers without affinity are tolerated, the token is
+// kept for next connection.
+func (c *Client) ConnectConn(conn net.Conn) error {
+	protocol := NewTcpProtocol(conn, false)
+	protocol.Framing = c.framing
+	protocol.MaxLength = c.Router.MaxRequestSize
+	c.attach(protocol)
+	if token := c.AffinityToken(); token != "" {
+		_, err := c.RequestAffinity(token)
+		if e, ok := err.(*ReplyError); ok && e.Code() == ErrNotFound {
+			// affinity unsupported
+			return nil
+		}
+		if err != nil {
+			c.Close()
+			return err
+		}
+	}
+	return nil
 }
 
-func (c *Client) handlePackets() {
+func (c *Client) attach(protocol Protocol) {
+	ctx := NewContext(protocol, c.Router, 0, c.serializer)
+	if c.timeout > 0 {
+		ctx.SetTimeout(c.timeout)
+	}
+	c.lock.Lock()
+	var old *Context
+	if c.state == ClientConnected {
+		old = c.context
+	}
+	c.context = ctx
+	c.state = ClientConnected
+	c.lock.Unlock()
+	if old != nil {
+		// replaced, close handlers are not called
+		old.Close()
+		old.Protocol.Close()
+	}
+	go c.handlePackets(ctx)
+}
+
+func (c *Client) handlePackets(ctx *Context) {
 	for {
-		packet, err := c.Protocol.ReadPacket()
+		packet, err := ctx.Protocol.ReadPacket()
 		if err != nil {
 			if err != io.EOF {
 				log.Println("Close on error", err)
 			}
-			c.Close()
+			c.closeContext(ctx)
 			break
 		}
-		go c.emitPacket(packet)
+		go ctx.emitPacket(packet)
 	}
 }
 
+// closeContext close ctx if it is the current connection, a replaced or
+// closed one is ignored.
+func (c *Client) closeContext(ctx *Context) error {
+	c.lock.Lock()
+	if ctx != c.context || c.state != ClientConnected {
+		c.lock.Unlock()
+		return nil
+	}
+	c.state = ClientClosed
+	handlers := c.closeHandlers
+	c.lock.Unlock()
+
+	ctx.Close()
+	err := ctx.Protocol.Close()
+	for _, handler := range handlers {
+		handler(c)
+	}
+	return err
+}
+
+// getContext returns context of current connection.
+func (c *Client) getContext() (*Context, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.state != ClientConnected {
+		return nil, newError(ErrNotConnected)
+	}
+	return c.context, nil
+}
+
+// State of connection.
+func (c *Client) State() ClientState {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.state
+}
+
+// RemoteAddr of current connection, nil if not connected by net.Conn.
+func (c *Client) RemoteAddr() net.Addr {
+	ctx, err := c.getContext()
+	if err != nil {
+		return nil
+	}
+	if p, ok := ctx.Protocol.(*TcpProtocol); ok && p.Conn != nil {
+		return p.Conn.RemoteAddr()
+	}
+	return nil
+}
+
+func (c *Client) SetSerializer(serializer Serializer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.serializer = serializer
+	c.Router.(*router).serializer = serializer
+	if c.context != nil {
+		c.context.serializer = serializer
+	}
+}
+
+// SetTimeout of calls.
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.timeout = timeout
+	if c.context != nil {
+		c.context.SetTimeout(timeout)
+	}
+}
+
+// OnMessage handle messages pushed by server.
 func (c *Client) OnMessage(code string, handler HandlerFunc) {
 	c.Router.AddRoute(code, handler)
 }
 
+// OnClose is called when connection is closed or lost.
+func (c *Client) OnClose(handler func(*Client)) {
+	c.lock.Lock()
+	c.closeHandlers = append(c.closeHandlers, handler)
+	c.lock.Unlock()
+}
+
+func (c *Client) SendMessage(code string, message Message) error {
+	ctx, err := c.getContext()
+	if err != nil {
+		return err
+	}
+	return ctx.SendMessage(code, message)
+}
+
+func (c *Client) SendUnreliable(key string, code string, message Message) error {
+	ctx, err := c.getContext()
+	if err != nil {
+		return err
+	}
+	return ctx.SendUnreliable(key, code, message)
+}
+
+func (c *Client) GetReply(code string, message Message) ([]byte, error) {
+	ctx, err := c.getContext()
+	if err != nil {
+		return nil, err
+	}
+	return ctx.GetReply(code, message)
+}
+
+func (c *Client) Call(code string, message Message, reply Message) error {
+	ctx, err := c.getContext()
+	if err != nil {
+		return err
+	}
+	return ctx.Call(code, message, reply)
+}
+
+// GetAsync is GetReply in background, the reply and error are sent to the
+// returned channels.
+func (c *Client) GetAsync(code string, message Message) (<-chan []byte, <-chan error) {
+	buffChan := make(chan []byte, 1)
+	errChan := make(chan error, 1)
+	go func() {
+		bytes, err := c.GetReply(code, message)
+		buffChan <- bytes
+		errChan <- err
+	}()
+	return buffChan, errChan
+}
+
+// RequestAffinity present token got from previous connection (or empty
+// string for first connect) and keep the token replied by server. Connect
+// presents the kept token.
+func (c *Client) RequestAffinity(token string) (string, error) {
+	bytes, err := c.GetReply(CodeAffinity, token)
+	if err != nil {
+		return "", err
+	}
+	c.affinityToken = string(bytes)
+	return c.affinityToken, nil
+}
+
+// AffinityToken returns the token should be presented on reconnect.
+func (c *Client) AffinityToken() string {
+	return c.affinityToken
+}
+
+// SetOutbox journal messages of SendDurable and SendDurableAck to store,
+// so they survive restart. Call FlushOutbox to resend pending messages.
+func (c *Client) SetOutbox(store OutboxStore) error {
+	o, err := newOutbox(store)
+	if err != nil {
+		return err
+	}
+	c.outbox = o
+	return nil
+}
+
+// SendDurable journal message before send, it is cleared once written.
+func (c *Client) SendDurable(code string, message Message) error {
+	return c.sendDurable(code, message, false)
+}
+
+// SendDurableAck journal message before send, it is cleared when server
+// responded. The message is kept in outbox if it is failed, e.g. timeout,
+// or the server asked to retry later, e.g. ErrOverloaded.
+func (c *Client) SendDurableAck(code string, message Message) error {
+	return c.sendDurable(code, message, true)
+}
+
+func (c *Client) sendDurable(code string, message Message, ack bool) error {
+	if c.outbox == nil {
+		return newError(ErrNoOutbox)
+	}
+	payload, err := MessageToBytes(message, c.serializer)
+	if err != nil {
+		return err
+	}
+	entry, err := c.outbox.append(code, payload, ack)
+	if err != nil {
+		return err
+	}
+	return c.sendEntry(entry)
+}
+
+// FlushOutbox resend pending messages in order, it stops at the first
+// failure.
+func (c *Client) FlushOutbox() error {
+	if c.outbox == nil {
+		return newError(ErrNoOutbox)
+	}
+	entries, err := c.outbox.store.Load()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !c.outbox.acquire(entry.Id) {
+			// sending by another goroutine
+			continue
+		}
+		if err := c.sendEntry(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) sendEntry(entry *OutboxEntry) error {
+	defer c.outbox.done(entry.Id)
+	var err error
+	if entry.Ack {
+		_, err = c.GetReply(entry.Code, entry.Payload)
+		if e, ok := err.(*ReplyError); err != nil && (!ok || retryableCodes[e.Code()]) {
+			// not acknowledged
+			return err
+		}
+	} else if err = c.SendMessage(entry.Code, entry.Payload); err != nil {
+		return err
+	}
+	if e := c.outbox.store.Remove(entry.Id); e != nil {
+		return e
+	}
+	// error replied by server
+	return err
+}
+
+// Close current connection.
 func (c *Client) Close() error {
-	c.Context.Close()
-	return c.Protocol.Close()
+	c.lock.Lock()
+	ctx := c.context
+	c.lock.Unlock()
+	return c.closeContext(ctx)
 }
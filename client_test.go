@@ -0,0 +1,57 @@
+package flyrpc
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientState(t *testing.T) {
+	client := NewClient(nil)
+	assert.Equal(t, ClientIdle, client.State())
+	err := client.SendMessage("hello", "a")
+	assert.Error(t, err)
+	assert.Equal(t, ErrNotConnected, err.Error())
+	assert.Nil(t, client.Close())
+
+	closed := make(chan bool, 2)
+	client.OnClose(func(c *Client) {
+		closed <- true
+	})
+	client.attach(NewMockProtocol())
+	assert.Equal(t, ClientConnected, client.State())
+	assert.Nil(t, client.Close())
+	assert.Nil(t, client.Close())
+	assert.Equal(t, ClientClosed, client.State())
+	assert.Equal(t, "closed", client.State().String())
+	assert.True(t, <-closed)
+	assert.Equal(t, 0, len(closed))
+}
+
+func TestClientConnect(t *testing.T) {
+	server := NewServer(&ServerOpts{Serializer: JSON})
+	server.OnMessage("echo", func(name string) string {
+		return name
+	})
+	go server.Listen("tcp", "127.0.0.1:15559")
+	defer server.Close()
+	time.Sleep(10 * time.Millisecond)
+
+	client := NewClient(&ClientOpts{Timeout: time.Second})
+	assert.Error(t, client.Connect("udp", "127.0.0.1:15559"))
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, client.Connect("tcp", "127.0.0.1:15559"))
+		assert.Equal(t, ClientConnected, client.State())
+		assert.Equal(t, "127.0.0.1:15559", client.RemoteAddr().(*net.TCPAddr).String())
+		bytes, err := client.GetReply("echo", "hi")
+		assert.Nil(t, err)
+		assert.Equal(t, "hi", string(bytes))
+		buffChan, errChan := client.GetAsync("echo", "async")
+		assert.Equal(t, "async", string(<-buffChan))
+		assert.Nil(t, <-errChan)
+		assert.Nil(t, client.Close())
+	}
+	assert.Nil(t, client.RemoteAddr())
+}
@@ -0,0 +1,256 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+
+	flyrpc "gopkg.in/flyrpc.v1"
+)
+
+// Hooks are parts can not be configured in file.
+type Hooks struct {
+	// AuditSink is required if audit is configured.
+	AuditSink     flyrpc.AuditSink
+	ScheduleStore flyrpc.ScheduleStore
+	OnQuarantine  func(code string, panics int)
+	// ShedPolicy customize load shedding, optional.
+	ShedPolicy func(code string, load *flyrpc.Load, shed bool) bool
+}
+
+var fieldNames = map[string]flyrpc.HeaderField{
+	"flag":   flyrpc.FieldFlag,
+	"seq":    flyrpc.FieldSeq,
+	"code":   flyrpc.FieldCode,
+	"length": flyrpc.FieldLength,
+}
+
+func (f *FramingConfig) profile() (*flyrpc.FramingProfile, error) {
+	if f == nil {
+		return nil, nil
+	}
+	profile := &flyrpc.FramingProfile{
+		Fields:      flyrpc.DefaultFraming.Fields,
+		LengthWidth: f.LengthWidth,
+		SeqWidth:    f.SeqWidth,
+		ErrorFlag:   f.ErrorFlag,
+	}
+	switch f.ByteOrder {
+	case "", "big":
+		profile.ByteOrder = binary.BigEndian
+	case "little":
+		profile.ByteOrder = binary.LittleEndian
+	default:
+		return nil, newError("unknown byteOrder " + f.ByteOrder)
+	}
+	switch f.CodeEncoding {
+	case "", "null":
+		profile.CodeEncoding = flyrpc.CodeNullTerminated
+	case "length":
+		profile.CodeEncoding = flyrpc.CodeLengthPrefixed
+	default:
+		return nil, newError("unknown codeEncoding " + f.CodeEncoding)
+	}
+	if len(f.Fields) > 0 {
+		profile.Fields = make([]flyrpc.HeaderField, len(f.Fields))
+		for i, name := range f.Fields {
+			field, ok := fieldNames[name]
+			if !ok {
+				return nil, newError("unknown framing field " + name)
+			}
+			profile.Fields[i] = field
+		}
+	}
+	return profile, nil
+}
+
+func (c *TLSConfig) config(server bool) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, newError("no certificate in " + c.CAFile)
+		}
+		if server {
+			config.ClientCAs = pool
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			config.RootCAs = pool
+		}
+	}
+	return config, nil
+}
+
+// Build create server, listeners are opened by Listen after handlers are
+// added.
+func (c *ServerConfig) Build(hooks *Hooks) (*flyrpc.Server, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	if hooks == nil {
+		hooks = &Hooks{}
+	}
+	opts := &flyrpc.ServerOpts{
+		Serializer:    Serializers[c.Serializer],
+		NodeId:        c.NodeId,
+		ScheduleStore: hooks.ScheduleStore,
+		MaxCaptures:   c.MaxCaptures,
+		Timeout:       c.Timeout.Duration(),
+	}
+	if c.MaxRequestSize > 0 || c.MaxResponseSize > 0 {
+		opts.RouteOpts = &flyrpc.RouteOpts{
+			MaxRequestSize:  c.MaxRequestSize,
+			MaxResponseSize: c.MaxResponseSize,
+		}
+	}
+	if c.Affinity != nil {
+		opts.AffinitySigner = flyrpc.NewAffinitySigner([]byte(c.Affinity.Secret))
+		opts.AffinitySigner.MaxAge = c.Affinity.MaxAge.Duration()
+	}
+	if q := c.Quarantine; q != nil {
+		opts.Quarantine = &flyrpc.QuarantinePolicy{
+			MaxPanics:    q.MaxPanics,
+			Window:       q.Window.Duration(),
+			Duration:     q.Duration.Duration(),
+			OnQuarantine: hooks.OnQuarantine,
+		}
+	}
+	if sh := c.Shedding; sh != nil {
+		opts.Shedding = &flyrpc.SheddingOpts{
+			MaxInFlight:   sh.MaxInFlight,
+			TargetLatency: sh.TargetLatency.Duration(),
+			StartPressure: sh.StartPressure,
+			Priorities:    sh.Priorities,
+			Policy:        hooks.ShedPolicy,
+		}
+	}
+	if a := c.Audit; a != nil {
+		if hooks.AuditSink == nil {
+			return nil, newError("require AuditSink hook for audit")
+		}
+		opts.Audit = &flyrpc.AuditOpts{
+			Sink:          hooks.AuditSink,
+			BatchSize:     a.BatchSize,
+			FlushInterval: a.FlushInterval.Duration(),
+			SampleRate:    a.SampleRate,
+			AlwaysCodes:   a.AlwaysCodes,
+		}
+	}
+	return flyrpc.NewServer(opts), nil
+}
+
+// Listen open all listeners and serve them in background, they are added to
+// server before it returns. Opened listeners are closed if any of them
+// failed.
+func (c *ServerConfig) Listen(server *flyrpc.Server) error {
+	listeners := make([]net.Listener, 0, len(c.Listeners))
+	framings := make([]*flyrpc.FramingProfile, 0, len(c.Listeners))
+	closeAll := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+	for _, lc := range c.Listeners {
+		framing, err := lc.Framing.profile()
+		if err != nil {
+			closeAll()
+			return err
+		}
+		var l net.Listener
+		if lc.TLS != nil {
+			var config *tls.Config
+			if config, err = lc.TLS.config(true); err == nil {
+				l, err = tls.Listen(lc.Network, lc.Address, config)
+			}
+		} else {
+			l, err = net.Listen(lc.Network, lc.Address)
+		}
+		if err != nil {
+			closeAll()
+			return err
+		}
+		listeners = append(listeners, l)
+		framings = append(framings, framing)
+	}
+	for i, l := range listeners {
+		if err := server.Start(l, framings[i]); err != nil {
+			closeAll()
+			return err
+		}
+	}
+	return nil
+}
+
+// Dial connect Address, or Discovery endpoints in order.
+func (c *ClientConfig) Dial() (*flyrpc.Client, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	framing, err := c.Framing.profile()
+	if err != nil {
+		return nil, err
+	}
+	var tlsConfig *tls.Config
+	if c.TLS != nil {
+		if tlsConfig, err = c.TLS.config(false); err != nil {
+			return nil, err
+		}
+	}
+	// configure before connected, packets may arrive once connected
+	client := flyrpc.NewClient(&flyrpc.ClientOpts{
+		Serializer: Serializers[c.Serializer],
+		Timeout:    c.Timeout.Duration(),
+		Framing:    framing,
+	})
+	var store *flyrpc.FileOutboxStore
+	if c.Outbox != "" {
+		if store, err = flyrpc.NewFileOutboxStore(c.Outbox); err != nil {
+			return nil, err
+		}
+		if err = client.SetOutbox(store); err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+	addrs := c.Discovery
+	if c.Address != "" {
+		addrs = append([]string{c.Address}, addrs...)
+	}
+	var conn net.Conn
+	for _, addr := range addrs {
+		if tlsConfig != nil {
+			conn, err = tls.Dial(c.Network, addr, tlsConfig)
+		} else {
+			conn, err = net.Dial(c.Network, addr)
+		}
+		if err == nil {
+			break
+		}
+	}
+	if err == nil {
+		err = client.ConnectConn(conn)
+	}
+	if err != nil {
+		if store != nil {
+			store.Close()
+		}
+		return nil, err
+	}
+	return client, nil
+}
@@ -0,0 +1,198 @@
+/*
+Package config build flyrpc Server and Client from YAML, JSON or environment
+variables, so deployments don't wire options in code.
+
+	cfg, err := config.Load("flyrpc.yaml")
+	cfg.ApplyEnv("FLYRPC")
+	server, err := cfg.Server.Build(&config.Hooks{})
+	server.OnMessage("hello", hello)
+	err = cfg.Server.Listen(server)
+*/
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	flyrpc "gopkg.in/flyrpc.v1"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	DefaultSerializer = "json"
+	DefaultTimeout    = 10 * time.Second
+	DefaultMaxPanics  = flyrpc.DefaultMaxPanics
+	DefaultWindow     = flyrpc.DefaultPanicWindow
+)
+
+// Serializers can be referenced by name in configuration.
+var Serializers = map[string]flyrpc.Serializer{
+	"json": flyrpc.JSON,
+}
+
+type Config struct {
+	Server *ServerConfig `json:"server" yaml:"server"`
+	Client *ClientConfig `json:"client" yaml:"client"`
+}
+
+type ServerConfig struct {
+	NodeId     string           `json:"nodeId" yaml:"nodeId"`
+	Serializer string           `json:"serializer" yaml:"serializer"`
+	Listeners  []ListenerConfig `json:"listeners" yaml:"listeners"`
+	// Timeout of calls to clients.
+	Timeout         Duration          `json:"timeout" yaml:"timeout"`
+	MaxRequestSize  int               `json:"maxRequestSize" yaml:"maxRequestSize"`
+	MaxResponseSize int               `json:"maxResponseSize" yaml:"maxResponseSize"`
+	MaxCaptures     int               `json:"maxCaptures" yaml:"maxCaptures"`
+	Affinity        *AffinityConfig   `json:"affinity" yaml:"affinity"`
+	Quarantine      *QuarantineConfig `json:"quarantine" yaml:"quarantine"`
+	Audit           *AuditConfig      `json:"audit" yaml:"audit"`
+	Shedding        *SheddingConfig   `json:"shedding" yaml:"shedding"`
+}
+
+type ListenerConfig struct {
+	Network string         `json:"network" yaml:"network"`
+	Address string         `json:"address" yaml:"address"`
+	TLS     *TLSConfig     `json:"tls" yaml:"tls"`
+	Framing *FramingConfig `json:"framing" yaml:"framing"`
+}
+
+type TLSConfig struct {
+	CertFile string `json:"certFile" yaml:"certFile"`
+	KeyFile  string `json:"keyFile" yaml:"keyFile"`
+	// CAFile verify peer certificates, client certificates for server.
+	CAFile             string `json:"caFile" yaml:"caFile"`
+	ServerName         string `json:"serverName" yaml:"serverName"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify" yaml:"insecureSkipVerify"`
+}
+
+type FramingConfig struct {
+	// ByteOrder is "big" or "little".
+	ByteOrder string `json:"byteOrder" yaml:"byteOrder"`
+	// Fields order, names are "flag", "seq", "code" and "length".
+	Fields      []string `json:"fields" yaml:"fields"`
+	LengthWidth int      `json:"lengthWidth" yaml:"lengthWidth"`
+	SeqWidth    int      `json:"seqWidth" yaml:"seqWidth"`
+	// CodeEncoding is "null" or "length".
+	CodeEncoding string `json:"codeEncoding" yaml:"codeEncoding"`
+	ErrorFlag    byte   `json:"errorFlag" yaml:"errorFlag"`
+}
+
+type AffinityConfig struct {
+	Secret string   `json:"secret" yaml:"secret"`
+	MaxAge Duration `json:"maxAge" yaml:"maxAge"`
+}
+
+type QuarantineConfig struct {
+	MaxPanics int      `json:"maxPanics" yaml:"maxPanics"`
+	Window    Duration `json:"window" yaml:"window"`
+	Duration  Duration `json:"duration" yaml:"duration"`
+}
+
+type AuditConfig struct {
+	BatchSize     int      `json:"batchSize" yaml:"batchSize"`
+	FlushInterval Duration `json:"flushInterval" yaml:"flushInterval"`
+	SampleRate    float64  `json:"sampleRate" yaml:"sampleRate"`
+	AlwaysCodes   []string `json:"alwaysCodes" yaml:"alwaysCodes"`
+}
+
+type SheddingConfig struct {
+	MaxInFlight   int            `json:"maxInFlight" yaml:"maxInFlight"`
+	TargetLatency Duration       `json:"targetLatency" yaml:"targetLatency"`
+	StartPressure float64        `json:"startPressure" yaml:"startPressure"`
+	Priorities    map[string]int `json:"priorities" yaml:"priorities"`
+}
+
+type ClientConfig struct {
+	Network string `json:"network" yaml:"network"`
+	// Address of server, Discovery endpoints are tried in order if it is
+	// empty or unreachable.
+	Address    string         `json:"address" yaml:"address"`
+	Discovery  []string       `json:"discovery" yaml:"discovery"`
+	Serializer string         `json:"serializer" yaml:"serializer"`
+	Timeout    Duration       `json:"timeout" yaml:"timeout"`
+	TLS        *TLSConfig     `json:"tls" yaml:"tls"`
+	Framing    *FramingConfig `json:"framing" yaml:"framing"`
+	// Outbox is the journal file of durable sends, optional.
+	Outbox string `json:"outbox" yaml:"outbox"`
+}
+
+// Duration is written as "10s", "1m30s" etc, or nanoseconds number.
+type Duration time.Duration
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d *Duration) parse(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return d.parse(s)
+	}
+	var n int64
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*d = Duration(n)
+	return nil
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		return d.parse(s)
+	}
+	var n int64
+	if err := unmarshal(&n); err != nil {
+		return err
+	}
+	*d = Duration(n)
+	return nil
+}
+
+// Load configuration file, format is decided by extension.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	format := strings.TrimPrefix(filepath.Ext(path), ".")
+	return Parse(data, format)
+}
+
+// Parse data of format "json", "yaml" or "yml", defaults are set and the
+// result is validated. Unknown options are rejected.
+func Parse(data []byte, format string) (*Config, error) {
+	cfg := &Config{}
+	var err error
+	switch format {
+	case "json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(cfg)
+	case "yaml", "yml":
+		err = yaml.UnmarshalStrict(data, cfg)
+	default:
+		return nil, newError("unknown format " + format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
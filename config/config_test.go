@@ -0,0 +1,122 @@
+package config
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	flyrpc "gopkg.in/flyrpc.v1"
+)
+
+func TestParseJSON(t *testing.T) {
+	cfg, err := Parse([]byte(`{
+		"server": {
+			"nodeId": "a",
+			"timeout": "3s",
+			"maxRequestSize": 1024,
+			"listeners": [
+				{"address": "127.0.0.1:15600"},
+				{"address": "127.0.0.1:15601", "framing": {
+					"byteOrder": "little",
+					"fields": ["length", "seq", "flag", "code"],
+					"lengthWidth": 4
+				}}
+			],
+			"affinity": {"secret": "s"},
+			"quarantine": {}
+		},
+		"client": {"address": "127.0.0.1:15600"}
+	}`), "json")
+	assert.Nil(t, err)
+	s := cfg.Server
+	assert.Equal(t, "json", s.Serializer)
+	assert.Equal(t, 3*time.Second, s.Timeout.Duration())
+	assert.Equal(t, "tcp", s.Listeners[0].Network)
+	assert.Equal(t, DefaultMaxPanics, s.Quarantine.MaxPanics)
+	assert.Equal(t, DefaultTimeout, cfg.Client.Timeout.Duration())
+
+	profile, err := s.Listeners[1].Framing.profile()
+	assert.Nil(t, err)
+	assert.Equal(t, flyrpc.FieldLength, profile.Fields[0])
+	assert.Equal(t, 4, profile.LengthWidth)
+}
+
+func TestValidate(t *testing.T) {
+	invalids := []string{
+		`{}`,
+		`{"server": {}}`,
+		`{"server": {"listeners": [{"network": "udp", "address": ":1"}]}}`,
+		`{"server": {"serializer": "xml", "listeners": [{"address": ":1"}]}}`,
+		`{"server": {"listeners": [{"address": ":1", "tls": {"certFile": "a"}}]}}`,
+		`{"server": {"listeners": [{"address": ":1", "framing": {"fields": ["flag"]}}]}}`,
+		`{"server": {"listeners": [{"address": ":1"}], "affinity": {"secret": "s"}}}`,
+		`{"server": {"listeners": [{"address": ":1"}], "audit": {"sampleRate": 2}}}`,
+		`{"server": {"listeners": [{"address": ":1"}], "shedding": {}}}`,
+		`{"server": {"listeners": [{"address": ":1"}], "discovery": [":2"]}}`,
+		`{"client": {}}`,
+	}
+	for _, data := range invalids {
+		_, err := Parse([]byte(data), "json")
+		assert.Error(t, err, data)
+	}
+	_, err := Parse([]byte(`{}`), "toml")
+	assert.Error(t, err)
+}
+
+func TestApplyEnv(t *testing.T) {
+	os.Setenv("FLYTEST_NODE_ID", "b")
+	os.Setenv("FLYTEST_LISTEN", "tcp://127.0.0.1:15602, unix:///tmp/fly.sock")
+	os.Setenv("FLYTEST_TIMEOUT", "5s")
+	os.Setenv("FLYTEST_CLIENT_DISCOVERY", "127.0.0.1:1,127.0.0.1:2")
+	defer func() {
+		for _, name := range []string{"NODE_ID", "LISTEN", "TIMEOUT", "CLIENT_DISCOVERY"} {
+			os.Unsetenv("FLYTEST_" + name)
+		}
+	}()
+	cfg, err := FromEnv("FLYTEST")
+	assert.Nil(t, err)
+	assert.Equal(t, "b", cfg.Server.NodeId)
+	assert.Equal(t, 5*time.Second, cfg.Server.Timeout.Duration())
+	assert.Equal(t, 2, len(cfg.Server.Listeners))
+	assert.Equal(t, "unix", cfg.Server.Listeners[1].Network)
+	assert.Equal(t, "/tmp/fly.sock", cfg.Server.Listeners[1].Address)
+	assert.Equal(t, []string{"127.0.0.1:1", "127.0.0.1:2"}, cfg.Client.Discovery)
+
+	os.Setenv("FLYTEST_TIMEOUT", "soon")
+	_, err = FromEnv("FLYTEST")
+	assert.Error(t, err)
+}
+
+func TestBuild(t *testing.T) {
+	cfg, err := Parse([]byte(`{
+		"server": {
+			"listeners": [{"address": "127.0.0.1:15603"}],
+			"maxRequestSize": 8,
+			"audit": {}
+		},
+		"client": {"address": "127.0.0.1:1", "discovery": ["127.0.0.1:15603"]}
+	}`), "json")
+	assert.Nil(t, err)
+	_, err = cfg.Server.Build(nil)
+	assert.Error(t, err)
+
+	cfg.Server.Audit = nil
+	server, err := cfg.Server.Build(nil)
+	assert.Nil(t, err)
+	server.OnMessage("echo", func(name string) string {
+		return name
+	})
+	assert.Nil(t, cfg.Server.Listen(server))
+	defer server.Close()
+
+	client, err := cfg.Client.Dial()
+	assert.Nil(t, err)
+	bytes, err := client.GetReply("echo", "hi")
+	assert.Nil(t, err)
+	assert.Equal(t, "hi", string(bytes))
+	_, err = client.GetReply("echo", "too large")
+	assert.Error(t, err)
+	assert.Equal(t, flyrpc.ErrPayloadTooLarge, err.Error())
+	client.Close()
+}
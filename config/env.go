@@ -0,0 +1,154 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// FromEnv build configuration from environment variables only.
+func FromEnv(prefix string) (*Config, error) {
+	cfg := &Config{}
+	if err := cfg.ApplyEnv(prefix); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// ApplyEnv override configuration by environment variables, then validate
+// it. Variables are prefix + "_" + name:
+//
+//	NODE_ID, SERIALIZER, TIMEOUT, MAX_REQUEST_SIZE, MAX_RESPONSE_SIZE,
+//	AFFINITY_SECRET,
+//	LISTEN (comma separated, e.g. "tcp://:5555,unix:///tmp/fly.sock"),
+//	TLS_CERT, TLS_KEY, TLS_CA (applied to all listeners),
+//	CLIENT_ADDRESS, CLIENT_DISCOVERY, CLIENT_TIMEOUT, CLIENT_OUTBOX.
+func (c *Config) ApplyEnv(prefix string) error {
+	env := envReader{prefix: prefix}
+	if env.has("NODE_ID", "SERIALIZER", "TIMEOUT", "MAX_REQUEST_SIZE",
+		"MAX_RESPONSE_SIZE", "AFFINITY_SECRET", "LISTEN",
+		"TLS_CERT", "TLS_KEY", "TLS_CA") && c.Server == nil {
+		c.Server = &ServerConfig{}
+	}
+	if s := c.Server; s != nil {
+		env.str("NODE_ID", &s.NodeId)
+		env.str("SERIALIZER", &s.Serializer)
+		if err := env.duration("TIMEOUT", &s.Timeout); err != nil {
+			return err
+		}
+		if err := env.int("MAX_REQUEST_SIZE", &s.MaxRequestSize); err != nil {
+			return err
+		}
+		if err := env.int("MAX_RESPONSE_SIZE", &s.MaxResponseSize); err != nil {
+			return err
+		}
+		if v, ok := env.get("AFFINITY_SECRET"); ok {
+			if s.Affinity == nil {
+				s.Affinity = &AffinityConfig{}
+			}
+			s.Affinity.Secret = v
+		}
+		if v, ok := env.get("LISTEN"); ok {
+			s.Listeners = nil
+			for _, addr := range splitList(v) {
+				l := ListenerConfig{Network: "tcp", Address: addr}
+				if i := strings.Index(addr, "://"); i >= 0 {
+					l.Network, l.Address = addr[:i], addr[i+3:]
+				}
+				s.Listeners = append(s.Listeners, l)
+			}
+		}
+		if env.has("TLS_CERT", "TLS_KEY", "TLS_CA") {
+			for i := range s.Listeners {
+				l := &s.Listeners[i]
+				if l.TLS == nil {
+					l.TLS = &TLSConfig{}
+				}
+				env.str("TLS_CERT", &l.TLS.CertFile)
+				env.str("TLS_KEY", &l.TLS.KeyFile)
+				env.str("TLS_CA", &l.TLS.CAFile)
+			}
+		}
+	}
+
+	if env.has("CLIENT_ADDRESS", "CLIENT_DISCOVERY", "CLIENT_TIMEOUT",
+		"CLIENT_OUTBOX") && c.Client == nil {
+		c.Client = &ClientConfig{}
+	}
+	if cc := c.Client; cc != nil {
+		env.str("CLIENT_ADDRESS", &cc.Address)
+		env.list("CLIENT_DISCOVERY", &cc.Discovery)
+		env.str("CLIENT_OUTBOX", &cc.Outbox)
+		if err := env.duration("CLIENT_TIMEOUT", &cc.Timeout); err != nil {
+			return err
+		}
+	}
+	return c.Validate()
+}
+
+type envReader struct {
+	prefix string
+}
+
+func (e envReader) get(name string) (string, bool) {
+	key := name
+	if e.prefix != "" {
+		key = e.prefix + "_" + name
+	}
+	return os.LookupEnv(key)
+}
+
+func (e envReader) has(names ...string) bool {
+	for _, name := range names {
+		if _, ok := e.get(name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (e envReader) str(name string, p *string) {
+	if v, ok := e.get(name); ok {
+		*p = v
+	}
+}
+
+func (e envReader) list(name string, p *[]string) {
+	if v, ok := e.get(name); ok {
+		*p = splitList(v)
+	}
+}
+
+func (e envReader) int(name string, p *int) error {
+	v, ok := e.get(name)
+	if !ok {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return newError("invalid " + name + ": " + v)
+	}
+	*p = n
+	return nil
+}
+
+func (e envReader) duration(name string, p *Duration) error {
+	v, ok := e.get(name)
+	if !ok {
+		return nil
+	}
+	if err := p.parse(v); err != nil {
+		return newError("invalid " + name + ": " + v)
+	}
+	return nil
+}
+
+func splitList(v string) []string {
+	list := []string{}
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,142 @@
+package config
+
+import "errors"
+
+func newError(msg string) error {
+	return errors.New("config: " + msg)
+}
+
+// Validate set defaults of omitted fields and check the configuration.
+func (c *Config) Validate() error {
+	if c.Server == nil && c.Client == nil {
+		return newError("require server or client")
+	}
+	if c.Server != nil {
+		if err := c.Server.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.Client != nil {
+		if err := c.Client.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ServerConfig) Validate() error {
+	if c.Serializer == "" {
+		c.Serializer = DefaultSerializer
+	}
+	if Serializers[c.Serializer] == nil {
+		return newError("unknown serializer " + c.Serializer)
+	}
+	if c.Timeout == 0 {
+		c.Timeout = Duration(DefaultTimeout)
+	}
+	if c.Timeout < 0 || c.MaxRequestSize < 0 || c.MaxResponseSize < 0 || c.MaxCaptures < 0 {
+		return newError("negative server limits")
+	}
+	if len(c.Listeners) == 0 {
+		return newError("require listeners")
+	}
+	for i := range c.Listeners {
+		l := &c.Listeners[i]
+		if err := validateAddress(&l.Network, l.Address); err != nil {
+			return err
+		}
+		if l.TLS != nil && (l.TLS.CertFile == "" || l.TLS.KeyFile == "") {
+			return newError("require certFile and keyFile of listener " + l.Address)
+		}
+		if err := l.Framing.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Affinity != nil {
+		if c.Affinity.Secret == "" {
+			return newError("require affinity secret")
+		}
+		if c.NodeId == "" {
+			return newError("require nodeId for affinity")
+		}
+	}
+	if q := c.Quarantine; q != nil {
+		if q.MaxPanics == 0 {
+			q.MaxPanics = DefaultMaxPanics
+		}
+		if q.Window == 0 {
+			q.Window = Duration(DefaultWindow)
+		}
+		if q.MaxPanics < 0 || q.Window < 0 || q.Duration < 0 {
+			return newError("negative quarantine options")
+		}
+	}
+	if sh := c.Shedding; sh != nil {
+		if sh.MaxInFlight <= 0 {
+			return newError("require shedding maxInFlight")
+		}
+		if sh.TargetLatency < 0 || sh.StartPressure < 0 || sh.StartPressure >= 1 {
+			return newError("invalid shedding options")
+		}
+	}
+	if a := c.Audit; a != nil {
+		if a.SampleRate < 0 || a.SampleRate > 1 {
+			return newError("audit sampleRate should be in [0, 1]")
+		}
+		if a.BatchSize < 0 || a.FlushInterval < 0 {
+			return newError("negative audit options")
+		}
+	}
+	return nil
+}
+
+func (c *ClientConfig) Validate() error {
+	if c.Serializer == "" {
+		c.Serializer = DefaultSerializer
+	}
+	if Serializers[c.Serializer] == nil {
+		return newError("unknown serializer " + c.Serializer)
+	}
+	if c.Timeout == 0 {
+		c.Timeout = Duration(DefaultTimeout)
+	}
+	if c.Timeout < 0 {
+		return newError("negative client timeout")
+	}
+	if c.Address == "" && len(c.Discovery) == 0 {
+		return newError("require client address or discovery")
+	}
+	if err := validateAddress(&c.Network, "-"); err != nil {
+		return err
+	}
+	return c.Framing.validate()
+}
+
+func validateAddress(network *string, address string) error {
+	if *network == "" {
+		*network = "tcp"
+	}
+	switch *network {
+	case "tcp", "unix":
+	default:
+		return newError("unsupported network " + *network)
+	}
+	if address == "" {
+		return newError("require address")
+	}
+	return nil
+}
+
+func (f *FramingConfig) validate() error {
+	if f == nil {
+		return nil
+	}
+	profile, err := f.profile()
+	if err != nil {
+		return err
+	}
+	if err := profile.Validate(); err != nil {
+		return newError("invalid framing: " + err.Error())
+	}
+	return nil
+}
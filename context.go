@@ -2,6 +2,7 @@ package flyrpc
 
 import (
 	"log"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,17 +14,25 @@ type Context struct {
 	Session  interface{}
 	Packet   *Packet
 	Router   Router
+	Affinity *AffinityToken
+	// Identity verified by application, e.g. user id after login.
+	Identity string
 	// private
 	serializer Serializer
 	nextSeq    TSeq
 	replyChans map[TSeq]chan *Packet
 	timeout    time.Duration
+	scheduler  *Scheduler
+	latest     *latestQueue
+	keySeqs    *keySeqs
+	// capture is *capture set by Server.StartCapture
+	capture atomic.Value
 	// close handler
 	closeHandler func(*Context)
 }
 
 func NewContext(protocol Protocol, router Router, clientId int, serializer Serializer) *Context {
-	return &Context{
+	ctx := &Context{
 		Protocol:   protocol,
 		Router:     router,
 		ClientId:   clientId,
@@ -31,6 +40,19 @@ func NewContext(protocol Protocol, router Router, clientId int, serializer Seria
 		replyChans: make(map[TSeq]chan *Packet),
 		timeout:    10 * time.Second,
 	}
+	ctx.scheduler = NewScheduler(func(msg *ScheduledMessage) error {
+		return ctx.SendMessage(msg.Code, msg.Payload)
+	}, nil)
+	ctx.keySeqs = newKeySeqs()
+	ctx.latest = newLatestQueue(func(pkt *Packet) error {
+		return ctx.writePacket(pkt)
+	})
+	return ctx
+}
+
+// SetTimeout of waiting reply.
+func (ctx *Context) SetTimeout(timeout time.Duration) {
+	ctx.timeout = timeout
 }
 
 func (ctx *Context) debug(args ...interface{}) {
@@ -42,8 +64,14 @@ func (ctx *Context) debug(args ...interface{}) {
 	}
 }
 
+// writePacket is the only way packets are sent through Protocol.
+func (ctx *Context) writePacket(pkt *Packet) error {
+	ctx.recordCapture(false, pkt)
+	return ctx.Protocol.SendPacket(pkt)
+}
+
 func (ctx *Context) sendPacket(flag byte, code string, seq TSeq, payload []byte) error {
-	return ctx.Protocol.SendPacket(&Packet{
+	return ctx.writePacket(&Packet{
 		ClientId: ctx.ClientId,
 		Flag:     flag,
 		Code:     code,
@@ -70,6 +98,26 @@ func (ctx *Context) SendMessage(code string, message Message) error {
 	return ctx.sendPacket(FlagWaitResponse, code, ctx.getNextSeq(), payload)
 }
 
+// SendAfter send message after delay, the message is dropped if context is
+// closed before that. Returns an id can be used by CancelSend.
+func (ctx *Context) SendAfter(delay time.Duration, code string, message Message) (int, error) {
+	payload, err := MessageToBytes(message, ctx.serializer)
+	if err != nil {
+		return 0, err
+	}
+	return ctx.scheduler.Schedule(&ScheduledMessage{
+		ClientId: ctx.ClientId,
+		Code:     code,
+		Payload:  payload,
+		At:       time.Now().Add(delay),
+	})
+}
+
+// CancelSend cancel a message scheduled by SendAfter.
+func (ctx *Context) CancelSend(id int) bool {
+	return ctx.scheduler.Cancel(id)
+}
+
 func (ctx *Context) GetReply(code string, message Message) ([]byte, error) {
 	ctx.debug("Call", code, message)
 
@@ -85,7 +133,7 @@ func (ctx *Context) GetReply(code string, message Message) ([]byte, error) {
 	}
 
 	// Send Packet
-	if err := ctx.Protocol.SendPacket(packet); err != nil {
+	if err := ctx.writePacket(packet); err != nil {
 		return nil, err
 	}
 
@@ -133,6 +181,11 @@ func (ctx *Context) GetAsync(code string, message Message) (chan<- []byte, chan<
 }
 
 func (ctx *Context) emitPacket(pkt *Packet) {
+	ctx.recordCapture(true, pkt)
+	if pkt.Key != "" && !ctx.keySeqs.accept(pkt.Key, pkt.Seq) {
+		ctx.debug("Drop stale packet", pkt.Key, pkt.Seq)
+		return
+	}
 	if pkt.Flag&FlagResponse != 0 {
 		replyChan := ctx.replyChans[pkt.Seq]
 		if replyChan == nil {
@@ -150,7 +203,7 @@ func (ctx *Context) emitPacket(pkt *Packet) {
 }
 
 func (ctx *Context) getNextSeq() TSeq {
-	ctx.nextSeq++
+	ctx.nextSeq = (ctx.nextSeq + 1) & MaxSeq
 	return ctx.nextSeq
 }
 
@@ -160,6 +213,8 @@ func (ctx *Context) OnClose(handler func(*Context)) {
 
 func (ctx *Context) Close() {
 	ctx.debug("closing")
+	ctx.scheduler.Stop()
+	ctx.latest.clear()
 	if ctx.closeHandler != nil {
 		ctx.closeHandler(ctx)
 	}
@@ -178,3 +178,10 @@ func TestPing(t *testing.T) {
 	}()
 
 }
+
+func TestNextSeqWrap(t *testing.T) {
+	context := NewContext(NewMockProtocol(), NewRouter(JSON), 0, JSON)
+	context.nextSeq = MaxSeq - 1
+	assert.Equal(t, MaxSeq, context.getNextSeq())
+	assert.Equal(t, TSeq(0), context.getNextSeq())
+}
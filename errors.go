@@ -7,7 +7,10 @@ It support Call/Response  or Send/Receive pattern.
 */
 package flyrpc
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 const (
 	// Common error
@@ -15,14 +18,24 @@ const (
 
 	// 10000 - 20000 client error
 
-	ErrNotFound       string = "NOT_FOUND"
-	ErrUnknownSubType string = "UNKNOWN_SUB_TYPE"
-	ErrBuffTooLong    string = "BUFF_TOO_LONG"
+	ErrNotFound        string = "NOT_FOUND"
+	ErrUnknownSubType  string = "UNKNOWN_SUB_TYPE"
+	ErrBuffTooLong     string = "BUFF_TOO_LONG"
+	ErrInvalidToken    string = "INVALID_TOKEN"
+	ErrPayloadTooLarge string = "PAYLOAD_TOO_LARGE"
 	// 20000 + server error
 
-	ErrNoWriter     string = "NO_WRITER"
-	ErrWriterClosed string = "WRITER_CLOSED"
-	ErrHandlerPanic string = "HANDLER_PANIC"
+	ErrNoWriter        string = "NO_WRITER"
+	ErrWriterClosed    string = "WRITER_CLOSED"
+	ErrHandlerPanic    string = "HANDLER_PANIC"
+	ErrClientClosed    string = "CLIENT_CLOSED"
+	ErrInvalidFraming  string = "INVALID_FRAMING"
+	ErrTooManyCaptures string = "TOO_MANY_CAPTURES"
+	ErrNoOutbox        string = "NO_OUTBOX"
+	ErrUnavailable     string = "UNAVAILABLE"
+	ErrOverloaded      string = "OVERLOADED"
+	ErrNotConnected    string = "NOT_CONNECTED"
+	ErrServerClosed    string = "SERVER_CLOSED"
 	// 25000 + serializer error
 
 	ErrNotProtoMessage string = "NOT_PROTOBUF_MESSAGE"
@@ -38,6 +51,16 @@ func (e *ReplyError) Error() string {
 	return e.code
 }
 
+// Code returns the error code sent on wire.
+func (e *ReplyError) Code() string {
+	return e.code
+}
+
+// Cause returns the detail of error, nil if it is received from remote.
+func (e *ReplyError) Cause() error {
+	return e.cause
+}
+
 func newReplyError(code string, pkt *Packet) *ReplyError {
 	return &ReplyError{
 		code: code,
@@ -55,3 +78,7 @@ func newFlyError(code string, cause error) error {
 		cause: cause,
 	}
 }
+
+func newPayloadTooLargeError(size, limit int) error {
+	return newFlyError(ErrPayloadTooLarge, fmt.Errorf("payload size %d exceeds limit %d", size, limit))
+}
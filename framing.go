@@ -0,0 +1,130 @@
+package flyrpc
+
+import "encoding/binary"
+
+// HeaderField is a field of packet header.
+type HeaderField byte
+
+const (
+	FieldFlag HeaderField = iota
+	FieldSeq
+	FieldCode
+	FieldLength
+)
+
+// CodeEncoding is how Code is written in header.
+type CodeEncoding byte
+
+const (
+	// CodeNullTerminated write Code followed by \0.
+	CodeNullTerminated CodeEncoding = iota
+	// CodeLengthPrefixed write uint16 length of Code then Code.
+	CodeLengthPrefixed
+)
+
+// FramingProfile describe how packet header is encoded, so peers using
+// a different header layout (e.g. older JS implementation) can be served on
+// another listener. Packets are translated to the default layout when read.
+type FramingProfile struct {
+	// ByteOrder of Seq and Length.
+	ByteOrder binary.ByteOrder
+	// Fields in the order they are written.
+	Fields []HeaderField
+	// LengthWidth is the fixed bytes of length: 1, 2, 4 or 8. Zero means
+	// the width is variable and stored in FlagLenPayload bits.
+	LengthWidth int
+	// SeqWidth is bytes of Seq: 2 or 4, zero means 2.
+	SeqWidth int
+	// CodeEncoding of Code, default CodeNullTerminated.
+	CodeEncoding CodeEncoding
+	// ErrorFlag if not zero, error response is written with this flag set
+	// and the error code as payload, instead of in Code. It is translated
+	// back when read, so handlers always find the error in Code. It must be
+	// a bit not defined by Flag Spec, 0x10 or 0x20.
+	ErrorFlag byte
+}
+
+// DefaultFraming is the layout described in README.
+var DefaultFraming = &FramingProfile{
+	ByteOrder: binary.BigEndian,
+	Fields:    []HeaderField{FieldFlag, FieldSeq, FieldCode, FieldLength},
+}
+
+// JSCompatFraming mirrors the Node.js flyrpc wire: 4 bytes seq, Code
+// prefixed by its length instead of \0 terminated, and error responses
+// flagged with 0x10 carrying the error code as payload. Serve Node.js peers
+// on a listener of this profile, Go peers dial it with DialFraming.
+var JSCompatFraming = &FramingProfile{
+	ByteOrder:    binary.BigEndian,
+	Fields:       []HeaderField{FieldFlag, FieldSeq, FieldCode, FieldLength},
+	SeqWidth:     4,
+	CodeEncoding: CodeLengthPrefixed,
+	ErrorFlag:    0x10,
+}
+
+// Validate check every field is present once, and Flag is before Length
+// if length width is variable.
+func (f *FramingProfile) Validate() error {
+	if f.ByteOrder == nil {
+		return newError(ErrInvalidFraming)
+	}
+	switch f.LengthWidth {
+	case 0, 1, 2, 4, 8:
+	default:
+		return newError(ErrInvalidFraming)
+	}
+	switch f.SeqWidth {
+	case 0, 2, 4:
+	default:
+		return newError(ErrInvalidFraming)
+	}
+	if f.CodeEncoding > CodeLengthPrefixed {
+		return newError(ErrInvalidFraming)
+	}
+	if f.ErrorFlag&(FlagResponse|FlagWaitResponse|FlagZipCode|FlagZipPayload|FlagLenPayload) != 0 {
+		return newError(ErrInvalidFraming)
+	}
+	if len(f.Fields) != 4 {
+		return newError(ErrInvalidFraming)
+	}
+	seen := make(map[HeaderField]bool)
+	for _, field := range f.Fields {
+		if field > FieldLength || seen[field] {
+			return newError(ErrInvalidFraming)
+		}
+		if field == FieldLength && f.LengthWidth == 0 && !seen[FieldFlag] {
+			return newError(ErrInvalidFraming)
+		}
+		seen[field] = true
+	}
+	return nil
+}
+
+// isError tell if pkt is an error response in Go layout.
+func (f *FramingProfile) isError(pkt *Packet) bool {
+	return pkt.Flag&FlagResponse != 0 && pkt.Code != ""
+}
+
+// toWire translate error response to ErrorFlag layout.
+func (f *FramingProfile) toWire(pkt *Packet) *Packet {
+	if f.ErrorFlag == 0 || !f.isError(pkt) {
+		return pkt
+	}
+	wire := *pkt
+	wire.Flag |= f.ErrorFlag
+	wire.Payload = []byte(pkt.Code)
+	wire.Length = 0
+	wire.Code = ""
+	return &wire
+}
+
+// fromWire translate ErrorFlag layout to error response.
+func (f *FramingProfile) fromWire(pkt *Packet) {
+	if f.ErrorFlag == 0 || pkt.Flag&f.ErrorFlag == 0 {
+		return
+	}
+	pkt.Flag &^= f.ErrorFlag
+	pkt.Code = string(pkt.Payload)
+	pkt.Payload = []byte{}
+	pkt.Length = 0
+}
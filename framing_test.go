@@ -0,0 +1,160 @@
+package flyrpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testLegacyFraming = &FramingProfile{
+	ByteOrder:   binary.LittleEndian,
+	Fields:      []HeaderField{FieldLength, FieldSeq, FieldFlag, FieldCode},
+	LengthWidth: 4,
+}
+
+func TestFramingValidate(t *testing.T) {
+	assert.Nil(t, DefaultFraming.Validate())
+	assert.Nil(t, testLegacyFraming.Validate())
+	assert.Error(t, (&FramingProfile{
+		ByteOrder: binary.BigEndian,
+		Fields:    []HeaderField{FieldLength, FieldSeq, FieldFlag, FieldCode},
+	}).Validate())
+	assert.Error(t, (&FramingProfile{
+		ByteOrder: binary.BigEndian,
+		Fields:    []HeaderField{FieldFlag, FieldFlag, FieldSeq, FieldCode},
+	}).Validate())
+	assert.Error(t, (&FramingProfile{
+		ByteOrder:   binary.BigEndian,
+		Fields:      DefaultFraming.Fields,
+		LengthWidth: 3,
+	}).Validate())
+}
+
+func TestFramingLegacy(t *testing.T) {
+	buf := &bytes.Buffer{}
+	p := newTcpProtocol(buf, buf, false)
+	p.Framing = testLegacyFraming
+	err := p.SendPacket(&Packet{
+		Flag:    FlagWaitResponse,
+		Code:    "hi",
+		Seq:     0x0102,
+		Payload: []byte{9, 8, 7},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{
+		3, 0, 0, 0, // length
+		0x02, 0x01, // seq
+		FlagWaitResponse,
+		'h', 'i', 0,
+		9, 8, 7,
+	}, buf.Bytes())
+
+	pkt, err := p.ReadPacket()
+	assert.Nil(t, err)
+	assert.Equal(t, FlagWaitResponse, pkt.Flag)
+	assert.Equal(t, TSeq(0x0102), pkt.Seq)
+	assert.Equal(t, "hi", pkt.Code)
+	assert.Equal(t, []byte{9, 8, 7}, pkt.Payload)
+}
+
+func TestFramingLengthOverflow(t *testing.T) {
+	buf := &bytes.Buffer{}
+	p := newTcpProtocol(buf, buf, false)
+	p.Framing = &FramingProfile{
+		ByteOrder:   binary.BigEndian,
+		Fields:      DefaultFraming.Fields,
+		LengthWidth: 1,
+	}
+	err := p.SendPacket(&Packet{Code: "x", Payload: make([]byte, 256)})
+	assert.Error(t, err)
+	assert.Equal(t, ErrBuffTooLong, err.Error())
+}
+
+func TestFramingCompat(t *testing.T) {
+	buf := &bytes.Buffer{}
+	p := newTcpProtocol(buf, buf, false)
+	p.Framing = &FramingProfile{
+		ByteOrder:    binary.BigEndian,
+		Fields:       DefaultFraming.Fields,
+		SeqWidth:     4,
+		CodeEncoding: CodeLengthPrefixed,
+		ErrorFlag:    0x10,
+	}
+	assert.Nil(t, p.Framing.Validate())
+
+	// error response is written with error in payload
+	pkt := &Packet{
+		Flag: FlagResponse,
+		Code: "FOO",
+		Seq:  0x10203,
+	}
+	assert.Nil(t, p.SendPacket(pkt))
+	assert.Equal(t, "FOO", pkt.Code)
+	assert.Equal(t, []byte{
+		FlagResponse | 0x10,
+		0, 1, 2, 3, // seq
+		0, 0, // code length
+		3, // length
+		'F', 'O', 'O',
+	}, buf.Bytes())
+	pkt, err := p.ReadPacket()
+	assert.Nil(t, err)
+	assert.Equal(t, FlagResponse, pkt.Flag)
+	assert.Equal(t, TSeq(0x10203), pkt.Seq)
+	assert.Equal(t, "FOO", pkt.Code)
+	assert.Equal(t, 0, len(pkt.Payload))
+
+	assert.Nil(t, p.SendPacket(&Packet{Code: "hello", Payload: []byte{1}}))
+	pkt, err = p.ReadPacket()
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", pkt.Code)
+	assert.Equal(t, []byte{1}, pkt.Payload)
+
+	for _, flag := range []byte{FlagResponse, FlagZipCode, FlagZipPayload} {
+		assert.Error(t, (&FramingProfile{
+			ByteOrder: binary.BigEndian,
+			Fields:    DefaultFraming.Fields,
+			ErrorFlag: flag,
+		}).Validate())
+	}
+}
+
+func TestJSCompatFraming(t *testing.T) {
+	assert.Nil(t, JSCompatFraming.Validate())
+	// request "echo" of seq 70000 with payload "hi", and the error response
+	golden := []byte{
+		FlagWaitResponse,
+		0, 1, 0x11, 0x70, // seq
+		0, 4, 'e', 'c', 'h', 'o',
+		2, // length
+		'h', 'i',
+		FlagResponse | 0x10,
+		0, 1, 0x11, 0x70, // seq
+		0, 0, // code
+		9, // length
+		'N', 'O', 'T', '_', 'F', 'O', 'U', 'N', 'D',
+	}
+	p := newTcpProtocol(bytes.NewReader(golden), ioutil.Discard, false)
+	p.Framing = JSCompatFraming
+	req, err := p.ReadPacket()
+	assert.Nil(t, err)
+	assert.Equal(t, FlagWaitResponse, req.Flag)
+	assert.Equal(t, TSeq(70000), req.Seq)
+	assert.Equal(t, "echo", req.Code)
+	assert.Equal(t, []byte("hi"), req.Payload)
+	resp, err := p.ReadPacket()
+	assert.Nil(t, err)
+	assert.Equal(t, FlagResponse, resp.Flag)
+	assert.Equal(t, ErrNotFound, resp.Code)
+
+	// translated back to the same bytes
+	buf := &bytes.Buffer{}
+	p = newTcpProtocol(buf, buf, false)
+	p.Framing = JSCompatFraming
+	assert.Nil(t, p.SendPacket(&Packet{Flag: FlagWaitResponse, Seq: 70000, Code: "echo", Payload: []byte("hi")}))
+	assert.Nil(t, p.SendPacket(&Packet{Flag: FlagResponse, Seq: 70000, Code: ErrNotFound}))
+	assert.Equal(t, golden, buf.Bytes())
+}
@@ -0,0 +1,74 @@
+package flyrpc
+
+import "sync/atomic"
+
+const sizeBuckets = 33
+
+// SizeHistogram count payload sizes by power of 2 buckets. Bucket 0 counts
+// empty payloads, bucket i counts sizes in [2^(i-1), 2^i), the last bucket
+// counts all larger sizes.
+type SizeHistogram struct {
+	count   int64
+	sum     int64
+	max     int64
+	buckets [sizeBuckets]int64
+}
+
+func sizeBucket(size int) int {
+	i := 0
+	for size > 0 && i < sizeBuckets-1 {
+		size >>= 1
+		i++
+	}
+	return i
+}
+
+func (h *SizeHistogram) Observe(size int) {
+	atomic.AddInt64(&h.count, 1)
+	atomic.AddInt64(&h.sum, int64(size))
+	atomic.AddInt64(&h.buckets[sizeBucket(size)], 1)
+	for {
+		max := atomic.LoadInt64(&h.max)
+		if int64(size) <= max || atomic.CompareAndSwapInt64(&h.max, max, int64(size)) {
+			break
+		}
+	}
+}
+
+func (h *SizeHistogram) Count() int64 {
+	return atomic.LoadInt64(&h.count)
+}
+
+func (h *SizeHistogram) Sum() int64 {
+	return atomic.LoadInt64(&h.sum)
+}
+
+func (h *SizeHistogram) Max() int64 {
+	return atomic.LoadInt64(&h.max)
+}
+
+// Buckets returns a snapshot of bucket counts.
+func (h *SizeHistogram) Buckets() []int64 {
+	buckets := make([]int64, sizeBuckets)
+	for i := range buckets {
+		buckets[i] = atomic.LoadInt64(&h.buckets[i])
+	}
+	return buckets
+}
+
+// RouteStats record payload sizes of a route.
+type RouteStats struct {
+	Requests  SizeHistogram
+	Responses SizeHistogram
+	// count of payloads rejected by size limit
+	requestTooLarge  int64
+	responseTooLarge int64
+}
+
+func (s *RouteStats) RequestTooLarge() int64 {
+	return atomic.LoadInt64(&s.requestTooLarge)
+}
+
+func (s *RouteStats) ResponseTooLarge() int64 {
+	return atomic.LoadInt64(&s.responseTooLarge)
+}
@@ -0,0 +1,23 @@
+package flyrpc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSizeHistogram(t *testing.T) {
+	h := &SizeHistogram{}
+	h.Observe(0)
+	h.Observe(1)
+	h.Observe(3)
+	h.Observe(1024)
+	assert.Equal(t, int64(4), h.Count())
+	assert.Equal(t, int64(1028), h.Sum())
+	assert.Equal(t, int64(1024), h.Max())
+	buckets := h.Buckets()
+	assert.Equal(t, int64(1), buckets[0])
+	assert.Equal(t, int64(1), buckets[1])
+	assert.Equal(t, int64(1), buckets[2])
+	assert.Equal(t, int64(1), buckets[11])
+}
@@ -0,0 +1,233 @@
+package flyrpc
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// retryableCodes are errors replied without handling the message, entries
+// are kept in outbox on them.
+var retryableCodes = map[string]bool{
+	ErrOverloaded:   true,
+	ErrUnavailable:  true,
+	ErrHandlerPanic: true,
+}
+
+// OutboxEntry is a message journaled before send.
+type OutboxEntry struct {
+	Id      int64
+	Code    string
+	Payload []byte
+	// Ack means the entry is cleared by server's response, otherwise it is
+	// cleared once written to connection.
+	Ack     bool
+	Created time.Time
+}
+
+// OutboxStore persist unsent messages, e.g. in a file or SQLite.
+type OutboxStore interface {
+	Append(*OutboxEntry) error
+	Remove(id int64) error
+	// Load returns pending entries in the order of Append.
+	Load() ([]*OutboxEntry, error)
+}
+
+type outboxRecord struct {
+	Op    string       `json:"op"`
+	Id    int64        `json:"id,omitempty"`
+	Entry *OutboxEntry `json:"entry,omitempty"`
+}
+
+// FileOutboxStore is an OutboxStore journal to a file, one JSON record per
+// line. The journal is compacted when opened.
+type FileOutboxStore struct {
+	lock    sync.Mutex
+	path    string
+	file    *os.File
+	entries []*OutboxEntry
+}
+
+func NewFileOutboxStore(path string) (*FileOutboxStore, error) {
+	s := &FileOutboxStore{path: path}
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileOutboxStore) replay() error {
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, int(^uint32(0)>>1))
+	for scanner.Scan() {
+		rec := &outboxRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), rec); err != nil {
+			// ignore the last partial line after crash
+			continue
+		}
+		if rec.Op == "add" && rec.Entry != nil {
+			s.entries = append(s.entries, rec.Entry)
+		} else if rec.Op == "del" {
+			s.remove(rec.Id)
+		}
+	}
+	return scanner.Err()
+}
+
+// compact rewrite journal with pending entries only.
+func (s *FileOutboxStore) compact() error {
+	tmp := s.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	for _, entry := range s.entries {
+		if err := writeOutboxRecord(file, &outboxRecord{Op: "add", Entry: entry}); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	file.Close()
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.file, err = os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0600)
+	return err
+}
+
+func writeOutboxRecord(file *os.File, rec *outboxRecord) error {
+	bytes, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(bytes, '\n'))
+	return err
+}
+
+func (s *FileOutboxStore) write(rec *outboxRecord) error {
+	if err := writeOutboxRecord(s.file, rec); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *FileOutboxStore) remove(id int64) {
+	for i, entry := range s.entries {
+		if entry.Id == id {
+			s.entries = append(s.entries[:i], s.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *FileOutboxStore) Append(entry *OutboxEntry) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.write(&outboxRecord{Op: "add", Entry: entry}); err != nil {
+		return err
+	}
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *FileOutboxStore) Remove(id int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.write(&outboxRecord{Op: "del", Id: id}); err != nil {
+		return err
+	}
+	s.remove(id)
+	return nil
+}
+
+func (s *FileOutboxStore) Load() ([]*OutboxEntry, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entries := make([]*OutboxEntry, len(s.entries))
+	copy(entries, s.entries)
+	return entries, nil
+}
+
+func (s *FileOutboxStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.file.Close()
+}
+
+// outbox journal messages of a client before send.
+type outbox struct {
+	store    OutboxStore
+	lock     sync.Mutex
+	nextId   int64
+	inflight map[int64]bool
+}
+
+func newOutbox(store OutboxStore) (*outbox, error) {
+	entries, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	o := &outbox{
+		store:    store,
+		inflight: make(map[int64]bool),
+	}
+	for _, entry := range entries {
+		if entry.Id > o.nextId {
+			o.nextId = entry.Id
+		}
+	}
+	return o, nil
+}
+
+func (o *outbox) append(code string, payload []byte, ack bool) (*OutboxEntry, error) {
+	o.lock.Lock()
+	o.nextId++
+	entry := &OutboxEntry{
+		Id:      o.nextId,
+		Code:    code,
+		Payload: payload,
+		Ack:     ack,
+		Created: time.Now(),
+	}
+	o.inflight[entry.Id] = true
+	o.lock.Unlock()
+	if err := o.store.Append(entry); err != nil {
+		o.done(entry.Id)
+		return nil, err
+	}
+	return entry, nil
+}
+
+// acquire mark entry in flight, returns false if it is being sent.
+func (o *outbox) acquire(id int64) bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.inflight[id] {
+		return false
+	}
+	o.inflight[id] = true
+	return true
+}
+
+func (o *outbox) done(id int64) {
+	o.lock.Lock()
+	delete(o.inflight, id)
+	o.lock.Unlock()
+}
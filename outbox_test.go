@@ -0,0 +1,91 @@
+package flyrpc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileOutboxStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flyrpc")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "outbox")
+
+	store, err := NewFileOutboxStore(path)
+	assert.Nil(t, err)
+	for i := int64(1); i <= 3; i++ {
+		assert.Nil(t, store.Append(&OutboxEntry{Id: i, Code: "c", Payload: []byte{byte(i)}}))
+	}
+	assert.Nil(t, store.Remove(2))
+	assert.Nil(t, store.Close())
+
+	store, err = NewFileOutboxStore(path)
+	assert.Nil(t, err)
+	entries, err := store.Load()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, int64(1), entries[0].Id)
+	assert.Equal(t, []byte{3}, entries[1].Payload)
+	assert.Nil(t, store.Close())
+}
+
+func TestClientOutbox(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flyrpc")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "outbox")
+
+	saved := make(chan int32, 10)
+	handler := func(u *TestUser) {
+		time.Sleep(20 * time.Millisecond)
+		saved <- u.Id
+	}
+
+	client := newClient(NewMockProtocol(), JSON)
+	client.OnMessage("save", handler)
+	assert.Error(t, client.SendDurable("save", &TestUser{Id: 1}))
+	store, err := NewFileOutboxStore(path)
+	assert.Nil(t, err)
+	assert.Nil(t, client.SetOutbox(store))
+
+	assert.Nil(t, client.SendDurableAck("save", &TestUser{Id: 1}))
+	assert.Equal(t, int32(1), <-saved)
+	entries, _ := store.Load()
+	assert.Equal(t, 0, len(entries))
+
+	// not acknowledged in time, kept in outbox
+	client.SetTimeout(time.Millisecond)
+	err = client.SendDurableAck("save", &TestUser{Id: 2})
+	assert.Error(t, err)
+	assert.Equal(t, ErrTimeOut, err.Error())
+	<-saved
+
+	// asked to retry later, kept in outbox
+	client.SetTimeout(time.Second)
+	client.Router.SetShedder(NewShedder(&SheddingOpts{MaxInFlight: 1}))
+	client.Router.(*router).shedder.admit("save")
+	err = client.SendDurableAck("save", &TestUser{Id: 3})
+	assert.Error(t, err)
+	assert.Equal(t, ErrOverloaded, err.Error())
+	entries, _ = store.Load()
+	assert.Equal(t, 2, len(entries))
+	assert.Nil(t, store.Close())
+
+	// restart
+	store, err = NewFileOutboxStore(path)
+	assert.Nil(t, err)
+	client = newClient(NewMockProtocol(), JSON)
+	client.OnMessage("save", handler)
+	assert.Nil(t, client.SetOutbox(store))
+	assert.Nil(t, client.FlushOutbox())
+	assert.Equal(t, int32(2), <-saved)
+	assert.Equal(t, int32(3), <-saved)
+	entries, _ = store.Load()
+	assert.Equal(t, 0, len(entries))
+	assert.Nil(t, store.Close())
+}
@@ -12,10 +12,17 @@ const (
 	FlagLenPayload   byte = 0x03
 )
 
-type TSeq uint16
+// TSeq is wide enough for peers using 4 bytes seq, seq generated locally
+// never exceeds MaxSeq. It was uint16 before FramingProfile.SeqWidth, code
+// converting it to uint16 must check MaxSeq.
+type TSeq uint32
 type TLength uint64
 
-const MaxLength = ^TLength(0)
+const (
+	MaxLength = ^TLength(0)
+	MaxSeq    = TSeq(0xffff)
+	maxInt    = int(^uint(0) >> 1)
+)
 
 type Packet struct {
 	// TODO remove this from packet
@@ -28,6 +35,8 @@ type Packet struct {
 	Length  TLength
 	Code    string
 	Payload []byte
+	// Key of packet sent by UnreliableProtocol, Seq is the sequence of Key.
+	Key string
 }
 
 type Protocol interface {
@@ -0,0 +1,106 @@
+package flyrpc
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	DefaultMaxPanics   = 5
+	DefaultPanicWindow = time.Minute
+)
+
+// QuarantinePolicy quarantine a route panics too often, requests to it are
+// replied ErrUnavailable instead of calling handler.
+type QuarantinePolicy struct {
+	// MaxPanics in Window to quarantine route, default DefaultMaxPanics and
+	// DefaultPanicWindow.
+	MaxPanics int
+	Window    time.Duration
+	// Duration of quarantine, zero means until Router.Release.
+	Duration time.Duration
+	// OnQuarantine is called when route code is quarantined, e.g. alert.
+	OnQuarantine func(code string, panics int)
+}
+
+type routeHealth struct {
+	panics      []time.Time
+	quarantined bool
+	until       time.Time
+}
+
+// SetQuarantine enable quarantine of panicking routes, nil to disable.
+func (router *router) SetQuarantine(policy *QuarantinePolicy) {
+	if policy != nil {
+		p := *policy
+		if p.MaxPanics <= 0 {
+			p.MaxPanics = DefaultMaxPanics
+		}
+		if p.Window <= 0 {
+			p.Window = DefaultPanicWindow
+		}
+		policy = &p
+	}
+	router.healthLock.Lock()
+	router.quarantine = policy
+	router.health = make(map[string]*routeHealth)
+	router.healthLock.Unlock()
+}
+
+// Release a quarantined route.
+func (router *router) Release(code string) {
+	router.healthLock.Lock()
+	delete(router.health, code)
+	router.healthLock.Unlock()
+}
+
+func (router *router) isQuarantined(code string) bool {
+	router.healthLock.Lock()
+	defer router.healthLock.Unlock()
+	h := router.health[code]
+	if h == nil || !h.quarantined {
+		return false
+	}
+	if !h.until.IsZero() && time.Now().After(h.until) {
+		delete(router.health, code)
+		return false
+	}
+	return true
+}
+
+func (router *router) recordPanic(code string) {
+	router.healthLock.Lock()
+	policy := router.quarantine
+	if policy == nil {
+		router.healthLock.Unlock()
+		return
+	}
+	h := router.health[code]
+	if h == nil {
+		h = &routeHealth{}
+		router.health[code] = h
+	}
+	now := time.Now()
+	// drop panics out of window
+	i := 0
+	for i < len(h.panics) && now.Sub(h.panics[i]) > policy.Window {
+		i++
+	}
+	h.panics = append(h.panics[i:], now)
+	panics := len(h.panics)
+	quarantine := !h.quarantined && panics >= policy.MaxPanics
+	if quarantine {
+		h.quarantined = true
+		if policy.Duration > 0 {
+			h.until = now.Add(policy.Duration)
+		}
+	}
+	router.healthLock.Unlock()
+
+	if quarantine {
+		log.Println("Command", code, "quarantined after", panics, "panics")
+		if policy.OnQuarantine != nil {
+			policy.OnQuarantine(code, panics)
+		}
+	}
+}
@@ -6,6 +6,9 @@ import (
 	"reflect"
 	"runtime/debug"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Message must be explicit type, e.g. *User
@@ -20,15 +23,34 @@ import (
 type HandlerFunc interface{}
 
 type Route interface {
-	emitPacket(*Context, *Packet) error
+	// rec is filled with the outcome if it is not nil
+	emitPacket(ctx *Context, pkt *Packet, rec *AuditRecord) error
 }
 
 type Router interface {
 	AddRoute(string, HandlerFunc)
+	AddRouteOpts(string, HandlerFunc, *RouteOpts)
 	GetRoute(string) Route
+	Stats(string) *RouteStats
+	MaxRequestSize(string) int
+	SetAuditor(*Auditor)
+	SetQuarantine(*QuarantinePolicy)
+	SetDefaultOpts(*RouteOpts)
+	SetShedder(*Shedder)
+	Release(string)
 	emitPacket(*Context, *Packet) error
 }
 
+type RouteOpts struct {
+	// MaxRequestSize limit request payload, checked when header is read, so
+	// TcpProtocol discard oversized payload without buffering it.
+	// Zero means unlimited.
+	MaxRequestSize int
+	// MaxResponseSize limit response payload, checked after marshal.
+	// Zero means unlimited.
+	MaxResponseSize int
+}
+
 type route struct {
 	serializer Serializer
 	handler    HandlerFunc
@@ -41,6 +63,11 @@ type route struct {
 	outTypes    []reflect.Type
 	outErrIndex int
 	outType     reflect.Type
+	// limits
+	maxRequest  int
+	maxResponse int
+	stats       *RouteStats
+	onPanic     func()
 }
 
 var (
@@ -59,6 +86,7 @@ func NewRoute(handlerFunc HandlerFunc, s Serializer) *route {
 		handler:     handlerFunc,
 		vHandler:    reflect.ValueOf(handlerFunc),
 		outErrIndex: -1,
+		stats:       &RouteStats{},
 	}
 	// FIXME better validate handler
 	if r.vHandler.Kind() != reflect.Func {
@@ -103,13 +131,39 @@ func (route *route) call(values []reflect.Value) (result []reflect.Value, err er
 			lines := strings.Split(string(debug.Stack()), "\n")
 			stack := strings.Join(lines[5:], "\n")
 			fmt.Printf("Error: %s\n%s", r, stack)
+			if route.onPanic != nil {
+				route.onPanic()
+			}
 		}
 	}()
 	result = route.vHandler.Call(values)
 	return
 }
 
-func (route *route) emitPacket(ctx *Context, pkt *Packet) error {
+// requestSize is Length of header if payload is discarded by protocol.
+func requestSize(pkt *Packet) int {
+	size := len(pkt.Payload)
+	if pkt.Length > TLength(size) {
+		if pkt.Length > TLength(maxInt) {
+			return maxInt
+		}
+		size = int(pkt.Length)
+	}
+	return size
+}
+
+func (route *route) replyError(ctx *Context, pkt *Packet, rec *AuditRecord, err error) error {
+	rec.setError(err)
+	return ctx.sendError(pkt.Code, pkt.Seq, err)
+}
+
+func (route *route) emitPacket(ctx *Context, pkt *Packet, rec *AuditRecord) error {
+	size := requestSize(pkt)
+	route.stats.Requests.Observe(size)
+	if route.maxRequest > 0 && size > route.maxRequest {
+		atomic.AddInt64(&route.stats.requestTooLarge, 1)
+		return route.replyError(ctx, pkt, rec, newPayloadTooLargeError(size, route.maxRequest))
+	}
 	values := make([]reflect.Value, route.numIn)
 	for i := 0; i < route.numIn; i++ {
 		inType := route.inTypes[i]
@@ -132,7 +186,7 @@ func (route *route) emitPacket(ctx *Context, pkt *Packet) error {
 	}
 	ret, err := route.call(values)
 	if err != nil {
-		return ctx.sendError(pkt.Code, pkt.Seq, err)
+		return route.replyError(ctx, pkt, rec, err)
 	}
 	// retSize := len(ret)
 	// if retSize != route.numOut {
@@ -143,7 +197,7 @@ func (route *route) emitPacket(ctx *Context, pkt *Packet) error {
 		if !ve.IsNil() {
 			err := ve.Interface().(error)
 			if err != nil {
-				return ctx.sendError(pkt.Code, pkt.Seq, err)
+				return route.replyError(ctx, pkt, rec, err)
 			}
 		}
 	}
@@ -165,6 +219,12 @@ func (route *route) emitPacket(ctx *Context, pkt *Packet) error {
 				return err
 			}
 		}
+		route.stats.Responses.Observe(len(bytes))
+		if route.maxResponse > 0 && len(bytes) > route.maxResponse {
+			atomic.AddInt64(&route.stats.responseTooLarge, 1)
+			return route.replyError(ctx, pkt, rec, newPayloadTooLargeError(len(bytes), route.maxResponse))
+		}
+		rec.setResponse(len(bytes))
 		return ctx.sendPacket(
 			FlagResponse,
 			"", // pkt.Code,
@@ -182,9 +242,15 @@ func (route *route) emitPacket(ctx *Context, pkt *Packet) error {
 }
 
 type router struct {
-	routes     map[string]Route
-	serializer Serializer
+	routes      map[string]Route
+	serializer  Serializer
+	auditor     *Auditor
+	defaultOpts *RouteOpts
+	shedder     *Shedder
 	// routesLock sync.RWMutex
+	quarantine *QuarantinePolicy
+	health     map[string]*routeHealth
+	healthLock sync.Mutex
 }
 
 func NewRouter(serializer Serializer) Router {
@@ -192,7 +258,21 @@ func NewRouter(serializer Serializer) Router {
 }
 
 func (router *router) AddRoute(code string, h HandlerFunc) {
+	router.AddRouteOpts(code, h, nil)
+}
+
+func (router *router) AddRouteOpts(code string, h HandlerFunc, opts *RouteOpts) {
 	route := NewRoute(h, router.serializer)
+	route.onPanic = func() {
+		router.recordPanic(code)
+	}
+	if opts == nil {
+		opts = router.defaultOpts
+	}
+	if opts != nil {
+		route.maxRequest = opts.MaxRequestSize
+		route.maxResponse = opts.MaxResponseSize
+	}
 	router.routes[code] = route
 }
 
@@ -200,11 +280,78 @@ func (router *router) GetRoute(code string) Route {
 	return router.routes[code]
 }
 
+// MaxRequestSize returns request limit of route code, zero means unlimited.
+func (router *router) MaxRequestSize(code string) int {
+	if rt, ok := router.routes[code].(*route); ok {
+		return rt.maxRequest
+	}
+	return 0
+}
+
+// Stats returns payload size stats of route code, nil if not found.
+func (router *router) Stats(code string) *RouteStats {
+	if rt, ok := router.routes[code].(*route); ok {
+		return rt.stats
+	}
+	return nil
+}
+
+// SetDefaultOpts is used by routes added without opts.
+func (router *router) SetDefaultOpts(opts *RouteOpts) {
+	router.defaultOpts = opts
+}
+
+// SetShedder reject requests when server is overloaded, nil to disable.
+func (router *router) SetShedder(shedder *Shedder) {
+	router.shedder = shedder
+}
+
+// SetAuditor record every handled request to auditor, nil to disable.
+func (router *router) SetAuditor(auditor *Auditor) {
+	router.auditor = auditor
+}
+
 func (router *router) emitPacket(ctx *Context, p *Packet) error {
+	var rec *AuditRecord
+	if router.auditor != nil {
+		rec = &AuditRecord{
+			Time:        time.Now(),
+			Code:        p.Code,
+			ClientId:    ctx.ClientId,
+			Identity:    ctx.Identity,
+			RequestSize: requestSize(p),
+		}
+		defer func() {
+			rec.Duration = time.Since(rec.Time)
+			router.auditor.Record(rec)
+		}()
+	}
 	rt := router.GetRoute(p.Code)
 	if rt == nil {
 		log.Println("Command", p.Code, "not found")
-		return ctx.sendError(p.Code, p.Seq, newError(ErrNotFound))
+		err := newError(ErrNotFound)
+		rec.setError(err)
+		return ctx.sendError(p.Code, p.Seq, err)
+	}
+	if router.isQuarantined(p.Code) {
+		err := newError(ErrUnavailable)
+		rec.setError(err)
+		return ctx.sendError(p.Code, p.Seq, err)
+	}
+	if shedder := router.shedder; shedder != nil {
+		if !shedder.admit(p.Code) {
+			err := newError(ErrOverloaded)
+			rec.setError(err)
+			return ctx.sendError(p.Code, p.Seq, err)
+		}
+		start := time.Now()
+		defer func() {
+			shedder.done(p.Code, time.Since(start))
+		}()
+	}
+	err := rt.emitPacket(ctx, p, rec)
+	if err != nil && rec != nil && rec.Outcome == "" {
+		rec.setError(err)
 	}
-	return rt.emitPacket(ctx, p)
+	return err
 }
@@ -3,6 +3,7 @@ package flyrpc
 import (
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -110,3 +111,96 @@ func TestRouterPanic(t *testing.T) {
 	})
 	assert.Nil(t, err)
 }
+
+func TestRouteSizeLimit(t *testing.T) {
+	s := JSON
+	payload, err := s.Marshal(&TestUser{Id: 123, Name: "abc"})
+	assert.Nil(t, err)
+	r := NewRouter(s)
+	protocol := NewMockProtocol()
+	ctx := NewContext(protocol, r, 0, s)
+
+	r.AddRouteOpts("1", func(u *TestUser) *TestUser {
+		return u
+	}, &RouteOpts{MaxRequestSize: 10})
+	err = r.emitPacket(ctx, &Packet{
+		Flag:    FlagWaitResponse,
+		Code:    "1",
+		Payload: payload,
+	})
+	assert.Nil(t, err)
+	pkt, err := protocol.ReadPacket()
+	assert.Nil(t, err)
+	assert.Equal(t, ErrPayloadTooLarge, pkt.Code)
+	assert.Equal(t, int64(1), r.Stats("1").RequestTooLarge())
+	assert.Equal(t, 10, r.MaxRequestSize("1"))
+
+	// payload discarded by protocol
+	assert.Nil(t, r.emitPacket(ctx, &Packet{Flag: FlagWaitResponse, Code: "1", Length: 1 << 40}))
+	pkt, err = protocol.ReadPacket()
+	assert.Nil(t, err)
+	assert.Equal(t, ErrPayloadTooLarge, pkt.Code)
+	assert.Equal(t, int64(2), r.Stats("1").RequestTooLarge())
+
+	r.AddRouteOpts("2", func(u *TestUser) *TestUser {
+		return &TestUser{Id: u.Id, Name: "abcdefghijklmnopqrstuvwxyz"}
+	}, &RouteOpts{MaxResponseSize: 40})
+	err = r.emitPacket(ctx, &Packet{
+		Flag:    FlagWaitResponse,
+		Code:    "2",
+		Payload: payload,
+	})
+	assert.Nil(t, err)
+	pkt, err = protocol.ReadPacket()
+	assert.Nil(t, err)
+	assert.Equal(t, ErrPayloadTooLarge, pkt.Code)
+
+	stats := r.Stats("2")
+	assert.Equal(t, int64(1), stats.ResponseTooLarge())
+	assert.Equal(t, int64(1), stats.Requests.Count())
+	assert.Equal(t, int64(len(payload)), stats.Requests.Max())
+	assert.Equal(t, int64(1), stats.Responses.Count())
+	assert.Nil(t, r.Stats("100"))
+}
+
+func TestRouteQuarantine(t *testing.T) {
+	s := JSON
+	r := NewRouter(s)
+	protocol := NewMockProtocol()
+	ctx := NewContext(protocol, r, 0, s)
+	alerts := make(chan string, 1)
+	r.SetQuarantine(&QuarantinePolicy{
+		MaxPanics: 2,
+		Window:    time.Minute,
+		OnQuarantine: func(code string, panics int) {
+			assert.Equal(t, 2, panics)
+			alerts <- code
+		},
+	})
+	calls := 0
+	r.AddRoute("1", func() {
+		calls++
+		panic("RouteTest panic")
+	})
+	expects := []string{ErrHandlerPanic, ErrHandlerPanic, ErrUnavailable}
+	for _, expect := range expects {
+		err := r.emitPacket(ctx, &Packet{Code: "1"})
+		assert.Nil(t, err)
+		pkt, err := protocol.ReadPacket()
+		assert.Nil(t, err)
+		assert.Equal(t, expect, pkt.Code)
+	}
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, "1", <-alerts)
+
+	r.Release("1")
+	r.emitPacket(ctx, &Packet{Code: "1"})
+	assert.Equal(t, 3, calls)
+
+	// defaults
+	policy := &QuarantinePolicy{}
+	r.SetQuarantine(policy)
+	assert.Equal(t, DefaultMaxPanics, r.(*router).quarantine.MaxPanics)
+	assert.Equal(t, DefaultPanicWindow, r.(*router).quarantine.Window)
+	assert.Equal(t, 0, policy.MaxPanics)
+}
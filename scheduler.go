@@ -0,0 +1,230 @@
+package flyrpc
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// ScheduledMessage is a message waiting to be pushed to a client.
+type ScheduledMessage struct {
+	Id int
+	// ClientId of recipient, only valid in this process, message is not
+	// persisted and dropped when the client disconnect.
+	ClientId int
+	// Key of recipient, e.g. Context.Identity, is stable across restarts.
+	// Message of Key is persisted and held until the key is connected.
+	Key     string
+	Code    string
+	Payload []byte
+	At      time.Time
+}
+
+// ScheduleStore persist scheduled messages of Key, so they can be restored
+// after restart. Save is called when a message is scheduled, Remove when
+// it is delivered or canceled.
+type ScheduleStore interface {
+	Save(*ScheduledMessage) error
+	Remove(id int) error
+	Load() ([]*ScheduledMessage, error)
+}
+
+// Scheduler deliver messages at a future time.
+type Scheduler struct {
+	send   func(*ScheduledMessage) error
+	store  ScheduleStore
+	lock   sync.Mutex
+	nextId int
+	timers map[int]*time.Timer
+	msgs   map[int]*ScheduledMessage
+	// messages of Key fired when the key is not connected
+	held map[string][]*ScheduledMessage
+}
+
+func NewScheduler(send func(*ScheduledMessage) error, store ScheduleStore) *Scheduler {
+	return &Scheduler{
+		send:   send,
+		store:  store,
+		timers: make(map[int]*time.Timer),
+		msgs:   make(map[int]*ScheduledMessage),
+		held:   make(map[string][]*ScheduledMessage),
+	}
+}
+
+// Schedule msg to be sent at msg.At, returns the id of scheduled message.
+func (s *Scheduler) Schedule(msg *ScheduledMessage) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if msg.Id == 0 {
+		s.nextId++
+		msg.Id = s.nextId
+	} else if msg.Id > s.nextId {
+		s.nextId = msg.Id
+	}
+	if s.store != nil && msg.Key != "" {
+		if err := s.store.Save(msg); err != nil {
+			return 0, err
+		}
+	}
+	s.msgs[msg.Id] = msg
+	s.timers[msg.Id] = time.AfterFunc(msg.At.Sub(time.Now()), func() {
+		s.fire(msg.Id)
+	})
+	return msg.Id, nil
+}
+
+// Restore load messages from store and schedule them again.
+// Messages already expired are sent immediately, or held until their key
+// is connected.
+func (s *Scheduler) Restore() error {
+	if s.store == nil {
+		return nil
+	}
+	msgs, err := s.store.Load()
+	if err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		if msg.Key == "" {
+			// ClientId of previous process
+			if err := s.store.Remove(msg.Id); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := s.Schedule(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resume deliver messages held for key, it should be called when key is
+// connected.
+func (s *Scheduler) Resume(key string) {
+	s.lock.Lock()
+	msgs := s.held[key]
+	delete(s.held, key)
+	s.lock.Unlock()
+	for i, msg := range msgs {
+		if err := s.send(msg); err != nil {
+			// disconnected again
+			s.lock.Lock()
+			s.held[key] = append(msgs[i:], s.held[key]...)
+			s.lock.Unlock()
+			return
+		}
+		s.removeStored(msg.Id)
+	}
+}
+
+// Cancel a scheduled message, returns false if it is already sent.
+func (s *Scheduler) Cancel(id int) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.cancel(id)
+}
+
+// CancelClient cancel scheduled messages of clientId, messages of Key are
+// kept.
+func (s *Scheduler) CancelClient(clientId int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for id, msg := range s.msgs {
+		if msg.Key == "" && msg.ClientId == clientId {
+			s.cancel(id)
+		}
+	}
+}
+
+// CancelKey cancel scheduled and held messages of key.
+func (s *Scheduler) CancelKey(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for id, msg := range s.msgs {
+		if msg.Key == key {
+			s.cancel(id)
+		}
+	}
+	for _, msg := range s.held[key] {
+		s.removeStored(msg.Id)
+	}
+	delete(s.held, key)
+}
+
+// Stop all timers, the persisted messages are kept in store.
+func (s *Scheduler) Stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for id, timer := range s.timers {
+		timer.Stop()
+		delete(s.timers, id)
+		delete(s.msgs, id)
+	}
+	s.held = make(map[string][]*ScheduledMessage)
+}
+
+// Len returns the count of pending messages, held messages included.
+func (s *Scheduler) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	n := len(s.msgs)
+	for _, msgs := range s.held {
+		n += len(msgs)
+	}
+	return n
+}
+
+func (s *Scheduler) cancel(id int) bool {
+	timer := s.timers[id]
+	if timer == nil {
+		return false
+	}
+	timer.Stop()
+	s.remove(id)
+	return true
+}
+
+func (s *Scheduler) remove(id int) {
+	msg := s.msgs[id]
+	delete(s.timers, id)
+	delete(s.msgs, id)
+	if msg != nil && msg.Key != "" {
+		s.removeStored(id)
+	}
+}
+
+func (s *Scheduler) removeStored(id int) {
+	if s.store != nil {
+		if err := s.store.Remove(id); err != nil {
+			log.Println("Remove scheduled message error", id, err)
+		}
+	}
+}
+
+func (s *Scheduler) fire(id int) {
+	s.lock.Lock()
+	msg := s.msgs[id]
+	if msg == nil {
+		// canceled or stopped
+		s.lock.Unlock()
+		return
+	}
+	delete(s.timers, id)
+	delete(s.msgs, id)
+	s.lock.Unlock()
+	err := s.send(msg)
+	if err != nil && msg.Key != "" {
+		// hold until key is connected, it is still in store
+		s.lock.Lock()
+		s.held[msg.Key] = append(s.held[msg.Key], msg)
+		s.lock.Unlock()
+		return
+	}
+	if msg.Key != "" {
+		s.removeStored(id)
+	}
+	if err != nil {
+		log.Println("Send scheduled message error", msg.Code, err)
+	}
+}
@@ -0,0 +1,182 @@
+package flyrpc
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memScheduleStore struct {
+	lock sync.Mutex
+	msgs map[int]*ScheduledMessage
+}
+
+func newMemScheduleStore() *memScheduleStore {
+	return &memScheduleStore{msgs: make(map[int]*ScheduledMessage)}
+}
+
+func (s *memScheduleStore) Save(msg *ScheduledMessage) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.msgs[msg.Id] = msg
+	return nil
+}
+
+func (s *memScheduleStore) Remove(id int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.msgs, id)
+	return nil
+}
+
+func (s *memScheduleStore) Load() ([]*ScheduledMessage, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	msgs := make([]*ScheduledMessage, 0, len(s.msgs))
+	for _, msg := range s.msgs {
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+func (s *memScheduleStore) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.msgs)
+}
+
+func TestContextSendAfter(t *testing.T) {
+	protocol := NewMockProtocol()
+	serializer := JSON
+	router := NewRouter(serializer)
+	context := NewContext(protocol, router, 0, serializer)
+
+	start := time.Now()
+	_, err := context.SendAfter(30*time.Millisecond, "buff", &TestUser{Id: 123})
+	assert.Nil(t, err)
+	id, err := context.SendAfter(10*time.Millisecond, "canceled", "x")
+	assert.Nil(t, err)
+	assert.True(t, context.CancelSend(id))
+	assert.Equal(t, false, context.CancelSend(id))
+
+	pkt, err := protocol.ReadPacket()
+	assert.Nil(t, err)
+	assert.True(t, time.Now().Sub(start) >= 30*time.Millisecond)
+	assert.Equal(t, "buff", pkt.Code)
+	u := &TestUser{}
+	assert.Nil(t, serializer.Unmarshal(pkt.Payload, u))
+	assert.Equal(t, int32(123), u.Id)
+}
+
+func TestContextSendAfterClose(t *testing.T) {
+	protocol := NewMockProtocol()
+	context := NewContext(protocol, NewRouter(JSON), 0, JSON)
+	_, err := context.SendAfter(10*time.Millisecond, "timer", "x")
+	assert.Nil(t, err)
+	context.Close()
+	assert.Equal(t, 0, context.scheduler.Len())
+	select {
+	case pkt := <-protocol.packetChan:
+		t.Error("unexpected packet", pkt.Code)
+	case <-time.After(30 * time.Millisecond):
+	}
+}
+
+func TestSchedulerStore(t *testing.T) {
+	store := newMemScheduleStore()
+	sent := make(chan *ScheduledMessage, 2)
+	send := func(msg *ScheduledMessage) error {
+		sent <- msg
+		return nil
+	}
+	s := NewScheduler(send, store)
+	_, err := s.Schedule(&ScheduledMessage{Key: "u1", Code: "a", At: time.Now().Add(time.Hour)})
+	assert.Nil(t, err)
+	_, err = s.Schedule(&ScheduledMessage{Key: "u2", Code: "b", At: time.Now().Add(time.Hour)})
+	assert.Nil(t, err)
+	// message of ClientId is not persisted
+	_, err = s.Schedule(&ScheduledMessage{ClientId: 1, Code: "c", At: time.Now().Add(time.Hour)})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, store.Len())
+	s.CancelClient(1)
+	assert.Equal(t, 2, s.Len())
+
+	// stop keeps persisted messages
+	s.Stop()
+	assert.Equal(t, 0, s.Len())
+	assert.Equal(t, 2, store.Len())
+
+	// cancel key removes persisted messages
+	s = NewScheduler(send, store)
+	assert.Nil(t, s.Restore())
+	assert.Equal(t, 2, s.Len())
+	s.CancelKey("u2")
+	assert.Equal(t, 1, store.Len())
+
+	id, err := s.Schedule(&ScheduledMessage{Key: "u3", Code: "d", At: time.Now()})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, id)
+	msg := <-sent
+	assert.Equal(t, "d", msg.Code)
+	assert.Equal(t, 1, store.Len())
+	s.Stop()
+}
+
+func TestSchedulerHold(t *testing.T) {
+	store := newMemScheduleStore()
+	offline := make(chan bool, 1)
+	sent := make(chan *ScheduledMessage, 2)
+	online := false
+	send := func(msg *ScheduledMessage) error {
+		if !online {
+			offline <- true
+			return newError(ErrClientClosed)
+		}
+		sent <- msg
+		return nil
+	}
+	s := NewScheduler(send, store)
+	_, err := s.Schedule(&ScheduledMessage{Key: "u1", Code: "a", At: time.Now()})
+	assert.Nil(t, err)
+	<-offline
+	// held until key is connected
+	for s.Len() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, 1, store.Len())
+
+	online = true
+	s.Resume("u1")
+	assert.Equal(t, "a", (<-sent).Code)
+	assert.Equal(t, 0, s.Len())
+	assert.Equal(t, 0, store.Len())
+}
+
+func TestServerSendAfterKey(t *testing.T) {
+	store := newMemScheduleStore()
+	store.Save(&ScheduledMessage{Id: 5, ClientId: 1, Code: "stale", At: time.Now()})
+	store.Save(&ScheduledMessage{Id: 7, Key: "u2", Code: "kept", At: time.Now().Add(time.Hour)})
+	server := NewServer(&ServerOpts{Serializer: JSON, ScheduleStore: store})
+	defer server.Close()
+	// the stale message of ClientId is dropped on restore
+	assert.Equal(t, 1, store.Len())
+
+	// id of restored message is not reused
+	id, err := server.SendAfterKey("u1", 0, "hello", "x")
+	assert.Nil(t, err)
+	assert.Equal(t, 8, id)
+	client := newClient(NewMockProtocol(), JSON)
+	received := make(chan string, 1)
+	client.OnMessage("hello", func(ctx *Context) {
+		received <- string(ctx.Packet.Payload)
+	})
+	ctx := NewContext(client.context.Protocol, server.Router, 1, JSON)
+	server.SetIdentity(ctx, "u1")
+	assert.Equal(t, "x", <-received)
+	for store.Len() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, "kept", store.msgs[7].Code)
+}
@@ -4,22 +4,61 @@ import (
 	"io"
 	"log"
 	"net"
+	"sync"
+	"time"
 )
 
 type ServerOpts struct {
 	Serializer Serializer
 	Multiplex  bool
+	// ScheduleStore persist messages scheduled by Server.SendAfterKey,
+	// optional.
+	ScheduleStore ScheduleStore
+	// NodeId identify this node in affinity tokens.
+	NodeId string
+	// AffinitySigner enable affinity handshake, optional.
+	AffinitySigner *AffinitySigner
+	// Audit record every handled request, optional.
+	Audit *AuditOpts
+	// MaxCaptures limit debug captures retained, including finished ones
+	// until StopCapture, default 4.
+	MaxCaptures int
+	// Quarantine routes panic too often, optional.
+	Quarantine *QuarantinePolicy
+	// Timeout of calls to clients, zero means default.
+	Timeout time.Duration
+	// RouteOpts is used by routes added without opts.
+	RouteOpts *RouteOpts
+	// Shedding reject low priority requests when overloaded, optional.
+	Shedding *SheddingOpts
 }
 
 type Server struct {
 	Router          Router
 	multiplex       bool
 	serializer      Serializer
-	listener        net.Listener
+	listeners       []net.Listener
 	transports      []*transport
+	connLock        sync.Mutex
+	closed          bool
 	contextMap      map[int]*Context
 	connectHandlers []func(*Context)
 	nextClientId    int
+	scheduler       *Scheduler
+	// affinity
+	nodeId           string
+	affinitySigner   *AffinitySigner
+	affinityHandlers []func(*Context, *AffinityToken)
+	auditor          *Auditor
+	// restoreErr is returned by Serve and SendAfterKey
+	restoreErr   error
+	identities   map[string]*Context
+	identityLock sync.Mutex
+	timeout      time.Duration
+	// debug capture
+	maxCaptures int
+	captures    map[int]*capture
+	captureLock sync.Mutex
 }
 
 type transport struct {
@@ -34,7 +73,7 @@ func NewServer(opts *ServerOpts) *Server {
 	if opts.Serializer == nil {
 		opts.Serializer = JSON
 	}
-	return &Server{
+	s := &Server{
 		Router:          NewRouter(opts.Serializer),
 		multiplex:       opts.Multiplex,
 		serializer:      opts.Serializer,
@@ -42,7 +81,36 @@ func NewServer(opts *ServerOpts) *Server {
 		contextMap:      make(map[int]*Context),
 		connectHandlers: make([]func(*Context), 0),
 		nextClientId:    0,
+		nodeId:          opts.NodeId,
+		affinitySigner:  opts.AffinitySigner,
+		maxCaptures:     opts.MaxCaptures,
+		timeout:         opts.Timeout,
+		captures:        make(map[int]*capture),
+		identities:      make(map[string]*Context),
 	}
+	if s.maxCaptures <= 0 {
+		s.maxCaptures = DefaultMaxCaptures
+	}
+	s.scheduler = NewScheduler(s.sendScheduled, opts.ScheduleStore)
+	// restore before any id is assigned, so persisted ids are not reused
+	s.restoreErr = s.scheduler.Restore()
+	if s.affinitySigner != nil {
+		s.Router.AddRoute(CodeAffinity, s.handleAffinity)
+	}
+	if opts.RouteOpts != nil {
+		s.Router.SetDefaultOpts(opts.RouteOpts)
+	}
+	if opts.Quarantine != nil {
+		s.Router.SetQuarantine(opts.Quarantine)
+	}
+	if opts.Shedding != nil {
+		s.Router.SetShedder(NewShedder(opts.Shedding))
+	}
+	if opts.Audit != nil {
+		s.auditor = NewAuditor(opts.Audit)
+		s.Router.SetAuditor(s.auditor)
+	}
+	return s
 }
 
 func (s *Server) Broadcast(clientIds []int, code string, v Message) error {
@@ -67,10 +135,20 @@ func (s *Server) OnConnect(connectHandler func(*Context)) {
 	s.connectHandlers = append(s.connectHandlers, connectHandler)
 }
 
+// OnAffinity handle clients presenting token owned by another node, e.g.
+// proxy the connection to token.Node.
+func (s *Server) OnAffinity(handler func(*Context, *AffinityToken)) {
+	s.affinityHandlers = append(s.affinityHandlers, handler)
+}
+
 func (s *Server) OnMessage(code string, handler HandlerFunc) {
 	s.Router.AddRoute(code, handler)
 }
 
+func (s *Server) OnMessageOpts(code string, handler HandlerFunc, opts *RouteOpts) {
+	s.Router.AddRouteOpts(code, handler, opts)
+}
+
 func (s *Server) emitContext(ctx *Context) {
 	for _, handler := range s.connectHandlers {
 		go handler(ctx)
@@ -81,39 +159,196 @@ func (s *Server) SendMessage(clientId int, code string, v int) error {
 	return s.GetContext(clientId).SendMessage(code, v)
 }
 
+// SendAfter send message to clientId after delay. The message is dropped if
+// the client disconnect before that, it is not persisted.
+func (s *Server) SendAfter(clientId int, delay time.Duration, code string, v Message) (int, error) {
+	payload, err := MessageToBytes(v, s.serializer)
+	if err != nil {
+		return 0, err
+	}
+	return s.scheduler.Schedule(&ScheduledMessage{
+		ClientId: clientId,
+		Code:     code,
+		Payload:  payload,
+		At:       time.Now().Add(delay),
+	})
+}
+
+// SendAfterKey send message to the client identified by key after delay,
+// see SetIdentity. The message is persisted by ScheduleStore, and held until
+// key is connected if it is not connected at that time.
+func (s *Server) SendAfterKey(key string, delay time.Duration, code string, v Message) (int, error) {
+	if s.restoreErr != nil {
+		return 0, s.restoreErr
+	}
+	payload, err := MessageToBytes(v, s.serializer)
+	if err != nil {
+		return 0, err
+	}
+	return s.scheduler.Schedule(&ScheduledMessage{
+		Key:     key,
+		Code:    code,
+		Payload: payload,
+		At:      time.Now().Add(delay),
+	})
+}
+
+// CancelSend cancel a message scheduled by SendAfter or SendAfterKey.
+func (s *Server) CancelSend(id int) bool {
+	return s.scheduler.Cancel(id)
+}
+
+// SetIdentity set ctx.Identity verified by application, e.g. after login,
+// and deliver messages held for it.
+func (s *Server) SetIdentity(ctx *Context, identity string) {
+	s.identityLock.Lock()
+	if ctx.Identity != "" && s.identities[ctx.Identity] == ctx {
+		delete(s.identities, ctx.Identity)
+	}
+	ctx.Identity = identity
+	s.identities[identity] = ctx
+	s.identityLock.Unlock()
+	go s.scheduler.Resume(identity)
+}
+
+func (s *Server) getIdentity(identity string) *Context {
+	s.identityLock.Lock()
+	defer s.identityLock.Unlock()
+	return s.identities[identity]
+}
+
+func (s *Server) removeIdentity(ctx *Context) {
+	s.identityLock.Lock()
+	if ctx.Identity != "" && s.identities[ctx.Identity] == ctx {
+		delete(s.identities, ctx.Identity)
+	}
+	s.identityLock.Unlock()
+}
+
+func (s *Server) sendScheduled(msg *ScheduledMessage) error {
+	var ctx *Context
+	if msg.Key != "" {
+		ctx = s.getIdentity(msg.Key)
+	} else {
+		ctx = s.GetContext(msg.ClientId)
+	}
+	if ctx == nil {
+		return newError(ErrClientClosed)
+	}
+	return ctx.SendMessage(msg.Code, msg.Payload)
+}
+
 func (s *Server) Listen(network, addr string) error {
+	return s.ListenFraming(network, addr, nil)
+}
+
+// ListenFraming listen with framing profile, so peers using different
+// header layout can connect to another address of the same server.
+func (s *Server) ListenFraming(network, addr string, framing *FramingProfile) error {
+	if framing != nil {
+		if err := framing.Validate(); err != nil {
+			return err
+		}
+	}
 	listener, err := net.Listen(network, addr)
 	if err != nil {
 		return err
 	}
-	s.listener = listener
-	s.handleConnections()
+	return s.Serve(listener, framing)
+}
+
+// Serve accept connections from listener, e.g. a TLS listener. framing is
+// optional. It blocks until listener is closed.
+func (s *Server) Serve(listener net.Listener, framing *FramingProfile) error {
+	if err := s.addListener(listener, framing); err != nil {
+		return err
+	}
+	s.handleConnections(listener, framing)
+	return nil
+}
+
+// Start is Serve in background, listener is added to server before it
+// returns, so Close always closes it.
+func (s *Server) Start(listener net.Listener, framing *FramingProfile) error {
+	if err := s.addListener(listener, framing); err != nil {
+		return err
+	}
+	go s.handleConnections(listener, framing)
+	return nil
+}
+
+// addListener validate framing and check persisted messages are restored,
+// listener is closed on error.
+func (s *Server) addListener(listener net.Listener, framing *FramingProfile) error {
+	if framing != nil {
+		if err := framing.Validate(); err != nil {
+			listener.Close()
+			return err
+		}
+	}
+	if s.restoreErr != nil {
+		listener.Close()
+		return s.restoreErr
+	}
+	s.connLock.Lock()
+	defer s.connLock.Unlock()
+	if s.closed {
+		listener.Close()
+		return newError(ErrServerClosed)
+	}
+	s.listeners = append(s.listeners, listener)
 	return nil
 }
 
 func (s *Server) Close() error {
-	for _, t := range s.transports {
+	// stop before closing transports, keep persisted messages
+	s.scheduler.Stop()
+	s.connLock.Lock()
+	s.closed = true
+	transports := s.transports
+	listeners := s.listeners
+	s.transports = nil
+	s.listeners = nil
+	s.connLock.Unlock()
+	for _, t := range transports {
 		t.Close()
 	}
-	err := s.listener.Close()
+	if s.auditor != nil {
+		s.auditor.Close()
+	}
+	var err error
+	for _, l := range listeners {
+		if e := l.Close(); e != nil {
+			err = e
+		}
+	}
 	return err
 }
 
-func (s *Server) handleConnections() {
+func (s *Server) handleConnections(listener net.Listener, framing *FramingProfile) {
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
 			log.Println("Accept error", err)
 			break
 		} else {
 			log.Println("New Connection", conn.RemoteAddr())
 		}
-		s.transports = append(s.transports, newTransport(conn, s))
+		s.connLock.Lock()
+		if s.closed {
+			s.connLock.Unlock()
+			conn.Close()
+			break
+		}
+		s.transports = append(s.transports, newTransport(conn, s, framing))
+		s.connLock.Unlock()
 	}
 }
 
-func newTransport(conn net.Conn, server *Server) *transport {
+func newTransport(conn net.Conn, server *Server, framing *FramingProfile) *transport {
 	protocol := NewTcpProtocol(conn, server.IsMultiplex())
+	protocol.Framing = framing
+	protocol.MaxLength = server.Router.MaxRequestSize
 	transport := &transport{
 		protocol: protocol,
 		server:   server,
@@ -168,6 +403,9 @@ func (t *transport) getContext(clientId int) *Context {
 func (t *transport) addClient(clientId int) *Context {
 	t.clientIds = append(t.clientIds, clientId)
 	context := NewContext(t.protocol, t.server.Router, clientId, t.server.serializer)
+	if t.server.timeout > 0 {
+		context.SetTimeout(t.server.timeout)
+	}
 	t.server.contextMap[clientId] = context
 	return context
 }
@@ -178,7 +416,9 @@ func (t *transport) removeClient(clientId int) *Context {
 	context := t.server.contextMap[clientId]
 	if context != nil {
 		context.Close()
+		t.server.removeIdentity(context)
 	}
+	t.server.scheduler.CancelClient(clientId)
 	delete(t.server.contextMap, clientId)
 	return context
 }
@@ -1,6 +1,7 @@
 package flyrpc
 
 import (
+	"net"
 	"sync"
 	"testing"
 	"time"
@@ -69,6 +70,26 @@ func TestServerHandlePacket(t *testing.T) {
 	server.Close()
 }
 
+func TestServerListenFraming(t *testing.T) {
+	server := NewServer(&ServerOpts{
+		Serializer: JSON,
+	})
+	server.OnMessage("echo", func(ctx *Context, name string) string {
+		return name
+	})
+	go func() {
+		err := server.ListenFraming("tcp", "127.0.0.1:15558", testLegacyFraming)
+		assert.Nil(t, err)
+	}()
+	<-time.After(10 * time.Millisecond)
+	client, err := DialFraming("tcp", "127.0.0.1:15558", testLegacyFraming)
+	assert.NoError(t, err)
+	bytes, err := client.GetReply("echo", "legacy")
+	assert.NoError(t, err)
+	assert.Equal(t, "legacy", string(bytes))
+	server.Close()
+}
+
 /*
 func TestServer(t *testing.T) {
 	server := NewServer(&ServerOpts{
@@ -127,3 +148,19 @@ func testClient(t *testing.T, wg *sync.WaitGroup, i int) {
 	})
 }
 */
+
+func TestServerStart(t *testing.T) {
+	server := NewServer(&ServerOpts{Serializer: JSON})
+	l, err := net.Listen("tcp", "127.0.0.1:15561")
+	assert.Nil(t, err)
+	assert.Nil(t, server.Start(l, nil))
+	server.Close()
+	_, err = net.Dial("tcp", "127.0.0.1:15561")
+	assert.Error(t, err)
+
+	l, err = net.Listen("tcp", "127.0.0.1:15561")
+	assert.Nil(t, err)
+	err = server.Start(l, nil)
+	assert.Error(t, err)
+	assert.Equal(t, ErrServerClosed, err.Error())
+}
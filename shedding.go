@@ -0,0 +1,173 @@
+package flyrpc
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	defaultStartPressure = 0.8
+	latencyDecay         = 0.2
+)
+
+// Load of server when a request arrives.
+type Load struct {
+	// InFlight requests of all commands, handlers are not returned yet.
+	InFlight int
+	// Latency is the moving average of all commands, it decays while no
+	// request completes.
+	Latency      time.Duration
+	CodeInFlight int
+	CodeLatency  time.Duration
+	Priority     int
+	// Pressure is the max of InFlight/MaxInFlight and Latency/TargetLatency,
+	// 1 means saturated.
+	Pressure float64
+}
+
+type SheddingOpts struct {
+	// MaxInFlight concurrent requests the server can handle.
+	MaxInFlight int
+	// TargetLatency of handlers, zero means latency is not considered.
+	TargetLatency time.Duration
+	// StartPressure to shed lowest priority commands, default 0.8. Higher
+	// priorities are shed in turn as pressure rises to 1, all commands are
+	// shed when InFlight reaches MaxInFlight.
+	StartPressure float64
+	// Priorities of commands, higher is more important, default 0.
+	Priorities map[string]int
+	// Policy customize shedding, shed is the default decision.
+	Policy func(code string, load *Load, shed bool) bool
+}
+
+type codeLoad struct {
+	inFlight int
+	latency  float64
+}
+
+// Shedder reject requests with ErrOverloaded when server is near saturation.
+type Shedder struct {
+	opts        SheddingOpts
+	maxPriority int
+	lock        sync.Mutex
+	inFlight    int
+	latency     float64
+	codes       map[string]*codeLoad
+	// updated is the time latency is updated or decayed
+	updated time.Time
+}
+
+func NewShedder(opts *SheddingOpts) *Shedder {
+	if opts.MaxInFlight <= 0 {
+		panic("require MaxInFlight")
+	}
+	s := &Shedder{
+		opts:  *opts,
+		codes: make(map[string]*codeLoad),
+	}
+	if s.opts.StartPressure <= 0 || s.opts.StartPressure >= 1 {
+		s.opts.StartPressure = defaultStartPressure
+	}
+	for _, p := range opts.Priorities {
+		if p > s.maxPriority {
+			s.maxPriority = p
+		}
+	}
+	return s
+}
+
+func (s *Shedder) codeLoad(code string) *codeLoad {
+	c := s.codes[code]
+	if c == nil {
+		c = &codeLoad{}
+		s.codes[code] = c
+	}
+	return c
+}
+
+// Load returns current load seen by request of code.
+func (s *Shedder) Load(code string) *Load {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.load(code)
+}
+
+// decay latency toward zero by time without completion, one step per
+// TargetLatency, so it recovers when requests are shed and nothing completes.
+func (s *Shedder) decay(now time.Time) {
+	if s.opts.TargetLatency <= 0 || s.updated.IsZero() {
+		return
+	}
+	steps := float64(now.Sub(s.updated)) / float64(s.opts.TargetLatency)
+	if steps <= 0 {
+		return
+	}
+	s.latency *= math.Pow(1-latencyDecay, steps)
+	s.updated = now
+}
+
+func (s *Shedder) load(code string) *Load {
+	s.decay(time.Now())
+	c := s.codeLoad(code)
+	load := &Load{
+		InFlight:     s.inFlight,
+		Latency:      time.Duration(s.latency),
+		CodeInFlight: c.inFlight,
+		CodeLatency:  time.Duration(c.latency),
+		Priority:     s.opts.Priorities[code],
+		Pressure:     float64(s.inFlight) / float64(s.opts.MaxInFlight),
+	}
+	if s.opts.TargetLatency > 0 {
+		if p := s.latency / float64(s.opts.TargetLatency); p > load.Pressure {
+			load.Pressure = p
+		}
+	}
+	return load
+}
+
+func (s *Shedder) shed(load *Load) bool {
+	if load.InFlight >= s.opts.MaxInFlight {
+		return true
+	}
+	start := s.opts.StartPressure
+	if load.Pressure < start {
+		return false
+	}
+	// priorities are shed in turn as pressure rises from start to 1
+	level := (load.Pressure - start) / (1 - start)
+	return level*float64(s.maxPriority+1) > float64(load.Priority)
+}
+
+// admit returns false if request of code should be shed, otherwise done must
+// be called when handler returns.
+func (s *Shedder) admit(code string) bool {
+	s.lock.Lock()
+	load := s.load(code)
+	shed := s.shed(load)
+	if s.opts.Policy != nil {
+		// release lock, policy may call Load
+		s.lock.Unlock()
+		shed = s.opts.Policy(code, load, shed)
+		s.lock.Lock()
+	}
+	if !shed {
+		s.inFlight++
+		s.codeLoad(code).inFlight++
+	}
+	s.lock.Unlock()
+	return !shed
+}
+
+func (s *Shedder) done(code string, d time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c := s.codeLoad(code)
+	s.inFlight--
+	c.inFlight--
+	now := time.Now()
+	s.decay(now)
+	s.updated = now
+	s.latency += latencyDecay * (float64(d) - s.latency)
+	c.latency += latencyDecay * (float64(d) - c.latency)
+}
@@ -0,0 +1,96 @@
+package flyrpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShedderPriority(t *testing.T) {
+	s := NewShedder(&SheddingOpts{
+		MaxInFlight:   4,
+		StartPressure: 0.5,
+		Priorities:    map[string]int{"pay": 1},
+	})
+	assert.True(t, s.admit("chat"))
+	assert.True(t, s.admit("chat"))
+	assert.True(t, s.admit("pay"))
+	// pressure 0.75, only lowest priority is shed
+	assert.Equal(t, false, s.admit("chat"))
+	assert.True(t, s.admit("pay"))
+	// saturated
+	assert.Equal(t, false, s.admit("pay"))
+
+	load := s.Load("pay")
+	assert.Equal(t, 4, load.InFlight)
+	assert.Equal(t, 2, load.CodeInFlight)
+	assert.Equal(t, 1, load.Priority)
+
+	for _, code := range []string{"chat", "chat", "pay", "pay"} {
+		s.done(code, time.Millisecond)
+	}
+	assert.True(t, s.admit("chat"))
+	assert.True(t, s.Load("chat").Latency > 0)
+}
+
+func TestShedderLatencyPolicy(t *testing.T) {
+	s := NewShedder(&SheddingOpts{
+		MaxInFlight:   100,
+		TargetLatency: 10 * time.Millisecond,
+		Policy: func(code string, load *Load, shed bool) bool {
+			return shed && code != "login"
+		},
+	})
+	for i := 0; i < 20; i++ {
+		assert.True(t, s.admit("login"))
+		s.done("login", time.Second)
+	}
+	assert.True(t, s.Load("slow").Pressure > 1)
+	assert.True(t, s.Load("login").CodeLatency > 10*time.Millisecond)
+	assert.Equal(t, false, s.admit("slow"))
+	assert.True(t, s.admit("login"))
+}
+
+func TestShedderRecovery(t *testing.T) {
+	s := NewShedder(&SheddingOpts{
+		MaxInFlight:   100,
+		TargetLatency: 10 * time.Millisecond,
+		Priorities:    map[string]int{"pay": 1},
+	})
+	for i := 0; i < 10; i++ {
+		assert.True(t, s.admit("pay"))
+	}
+	for i := 0; i < 10; i++ {
+		s.done("pay", 100*time.Millisecond)
+	}
+	for i := 0; i < 1000; i++ {
+		assert.Equal(t, false, s.admit("pay"))
+	}
+
+	// load dropped, latency decays while everything is shed
+	time.Sleep(200 * time.Millisecond)
+	assert.True(t, s.Load("pay").Pressure < defaultStartPressure)
+	assert.True(t, s.admit("pay"))
+}
+
+func TestRouterShedding(t *testing.T) {
+	r := NewRouter(JSON)
+	r.SetShedder(NewShedder(&SheddingOpts{MaxInFlight: 1}))
+	protocol := NewMockProtocol()
+	ctx := NewContext(protocol, r, 0, JSON)
+	block := make(chan bool)
+	r.AddRoute("slow", func() {
+		<-block
+	})
+	go r.emitPacket(ctx, &Packet{Code: "slow"})
+	for r.(*router).shedder.Load("slow").InFlight == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Nil(t, r.emitPacket(ctx, &Packet{Code: "slow", Seq: 2}))
+	pkt, err := protocol.ReadPacket()
+	assert.Nil(t, err)
+	assert.Equal(t, ErrOverloaded, pkt.Code)
+	assert.Equal(t, TSeq(2), pkt.Seq)
+	close(block)
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"encoding/binary"
 	"io"
+	"io/ioutil"
 	"net"
 	"reflect"
 )
@@ -15,6 +16,11 @@ type TcpProtocol struct {
 	Reader *bufio.Reader
 	// Writer
 	Writer *bufio.Writer
+	// Framing of header, nil means DefaultFraming
+	Framing *FramingProfile
+	// MaxLength limit payload of requests by code, zero means unlimited.
+	// Oversized payload is discarded without being buffered, optional.
+	MaxLength func(code string) int
 }
 
 func NewTcpProtocol(conn net.Conn, isMultiplex bool) *TcpProtocol {
@@ -54,6 +60,7 @@ func (p *TcpProtocol) SendPacket(pk *Packet) error {
 	// TODO zip
 	// payload = zip(payload)
 	// }
+	pk = p.framing().toWire(pk)
 	if pk.Length == 0 {
 		pk.Length = TLength(len(pk.Payload))
 	}
@@ -75,58 +82,82 @@ func (p *TcpProtocol) SendPacket(pk *Packet) error {
 	return p.Writer.Flush()
 }
 
+func (p *TcpProtocol) framing() *FramingProfile {
+	if p.Framing == nil {
+		return DefaultFraming
+	}
+	return p.Framing
+}
+
 func (p *TcpProtocol) SendHeader(pk *Packet) error {
-	var sizeOfLength byte
-	if pk.Length > 0xffffffff {
-		sizeOfLength = 8
-		pk.Flag = pk.Flag | 0x03
-	} else if pk.Length > 0xffff {
-		sizeOfLength = 4
-		pk.Flag = pk.Flag | 0x02
-	} else if pk.Length > 0xff {
-		sizeOfLength = 2
-		pk.Flag = pk.Flag | 0x01
-	} else {
-		sizeOfLength = 1
-	}
-
-	// write Flag
-	if err := binary.Write(p.Writer, binary.BigEndian, pk.Flag); err != nil {
-		return err
+	f := p.framing()
+	sizeOfLength := f.LengthWidth
+	if sizeOfLength == 0 {
+		if pk.Length > 0xffffffff {
+			sizeOfLength = 8
+			pk.Flag = pk.Flag | 0x03
+		} else if pk.Length > 0xffff {
+			sizeOfLength = 4
+			pk.Flag = pk.Flag | 0x02
+		} else if pk.Length > 0xff {
+			sizeOfLength = 2
+			pk.Flag = pk.Flag | 0x01
+		} else {
+			sizeOfLength = 1
+		}
+	} else if sizeOfLength < 8 && pk.Length >= TLength(1)<<(8*uint(sizeOfLength)) {
+		return newError(ErrBuffTooLong)
 	}
 
-	// write Seq
-	if err := binary.Write(p.Writer, binary.BigEndian, pk.Seq); err != nil {
-		return err
+	for _, field := range f.Fields {
+		var err error
+		switch field {
+		case FieldFlag:
+			err = p.Writer.WriteByte(pk.Flag)
+		case FieldSeq:
+			if f.SeqWidth == 4 {
+				err = binary.Write(p.Writer, f.ByteOrder, uint32(pk.Seq))
+			} else {
+				err = binary.Write(p.Writer, f.ByteOrder, uint16(pk.Seq))
+			}
+		case FieldCode:
+			err = p.writeCode(f, pk.Code)
+		case FieldLength:
+			err = p.writeLength(f.ByteOrder, sizeOfLength, pk.Length)
+		}
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	// write Code
-	if _, err := p.Writer.WriteString(pk.Code); err != nil {
+func (p *TcpProtocol) writeCode(f *FramingProfile, code string) error {
+	if f.CodeEncoding == CodeLengthPrefixed {
+		if len(code) > 0xffff {
+			return newError(ErrBuffTooLong)
+		}
+		if err := binary.Write(p.Writer, f.ByteOrder, uint16(len(code))); err != nil {
+			return err
+		}
+		_, err := p.Writer.WriteString(code)
 		return err
 	}
-	if err := p.Writer.WriteByte(0); err != nil {
+	if _, err := p.Writer.WriteString(code); err != nil {
 		return err
 	}
+	return p.Writer.WriteByte(0)
+}
 
-	// write Payload Length
+func (p *TcpProtocol) writeLength(order binary.ByteOrder, sizeOfLength int, length TLength) error {
 	if sizeOfLength == 1 {
-		if err := p.Writer.WriteByte(byte(pk.Length)); err != nil {
-			return err
-		}
+		return p.Writer.WriteByte(byte(length))
 	} else if sizeOfLength == 2 {
-		if err := binary.Write(p.Writer, binary.BigEndian, uint16(pk.Length)); err != nil {
-			return err
-		}
+		return binary.Write(p.Writer, order, uint16(length))
 	} else if sizeOfLength == 4 {
-		if err := binary.Write(p.Writer, binary.BigEndian, uint32(pk.Length)); err != nil {
-			return err
-		}
-	} else if sizeOfLength == 8 {
-		if err := binary.Write(p.Writer, binary.BigEndian, uint64(pk.Length)); err != nil {
-			return err
-		}
+		return binary.Write(p.Writer, order, uint32(length))
 	}
-	return nil
+	return binary.Write(p.Writer, order, uint64(length))
 }
 
 func (p *TcpProtocol) ReadPacket() (*Packet, error) {
@@ -138,69 +169,98 @@ func (p *TcpProtocol) ReadPacket() (*Packet, error) {
 		return nil, err
 	}
 
+	if p.MaxLength != nil && pkt.Flag&FlagResponse == 0 {
+		if max := p.MaxLength(pkt.Code); max > 0 && pkt.Length > TLength(max) {
+			// keep Length, so router can reply ErrPayloadTooLarge
+			if pkt.Length > TLength(maxInt) {
+				return nil, newError(ErrBuffTooLong)
+			}
+			if _, err := io.CopyN(ioutil.Discard, reader, int64(pkt.Length)); err != nil {
+				return nil, err
+			}
+			return pkt, nil
+		}
+	}
+
 	// read Payload
 	pkt.Payload = make([]byte, pkt.Length)
 	if _, err := io.ReadFull(reader, pkt.Payload); err != nil {
 		return nil, err
 	}
 	// TODO unzip
+	p.framing().fromWire(pkt)
 	return pkt, nil
 }
 
 func (p *TcpProtocol) ReadHeader(pkt *Packet) error {
+	f := p.framing()
 	reader := p.Reader
 
-	var err error
-	// read Flag
-	pkt.Flag, err = reader.ReadByte()
-	if err != nil {
-		return err
+	for _, field := range f.Fields {
+		var err error
+		switch field {
+		case FieldFlag:
+			pkt.Flag, err = reader.ReadByte()
+		case FieldSeq:
+			if f.SeqWidth == 4 {
+				var seq uint32
+				err = binary.Read(reader, f.ByteOrder, &seq)
+				pkt.Seq = TSeq(seq)
+			} else {
+				var seq uint16
+				err = binary.Read(reader, f.ByteOrder, &seq)
+				pkt.Seq = TSeq(seq)
+			}
+		case FieldCode:
+			pkt.Code, err = p.readCode(f)
+		case FieldLength:
+			sizeOfLength := f.LengthWidth
+			if sizeOfLength == 0 {
+				sizeOfLength = 1 << (pkt.Flag & FlagLenPayload)
+			}
+			pkt.Length, err = p.readLength(f.ByteOrder, sizeOfLength)
+		}
+		if err != nil {
+			return err
+		}
 	}
-	powOfLength := pkt.Flag & FlagLenPayload
+	return nil
+}
 
-	// read Seq
-	var seq uint16
-	err = binary.Read(reader, binary.BigEndian, &seq)
-	if err != nil {
-		return err
+func (p *TcpProtocol) readCode(f *FramingProfile) (string, error) {
+	if f.CodeEncoding == CodeLengthPrefixed {
+		var l uint16
+		if err := binary.Read(p.Reader, f.ByteOrder, &l); err != nil {
+			return "", err
+		}
+		code := make([]byte, l)
+		if _, err := io.ReadFull(p.Reader, code); err != nil {
+			return "", err
+		}
+		return string(code), nil
 	}
-	pkt.Seq = TSeq(seq)
-
-	// read Code
-	code, err := reader.ReadString(0)
+	code, err := p.Reader.ReadString(0)
 	if err != nil {
-		return err
+		return "", err
 	}
-	pkt.Code = code[:len(code)-1]
+	return code[:len(code)-1], nil
+}
 
-	// read length
-	if powOfLength == 0 {
+func (p *TcpProtocol) readLength(order binary.ByteOrder, sizeOfLength int) (TLength, error) {
+	reader := p.Reader
+	if sizeOfLength == 1 {
 		l, err := reader.ReadByte()
-		if err != nil {
-			return err
-		}
-		pkt.Length = TLength(l)
-	} else if powOfLength == 1 {
+		return TLength(l), err
+	} else if sizeOfLength == 2 {
 		var l uint16
-		err = binary.Read(reader, binary.BigEndian, &l)
-		if err != nil {
-			return err
-		}
-		pkt.Length = TLength(l)
-	} else if powOfLength == 2 {
+		err := binary.Read(reader, order, &l)
+		return TLength(l), err
+	} else if sizeOfLength == 4 {
 		var l uint32
-		err = binary.Read(reader, binary.BigEndian, &l)
-		if err != nil {
-			return err
-		}
-		pkt.Length = TLength(l)
-	} else if powOfLength == 3 {
-		var l uint64
-		err = binary.Read(reader, binary.BigEndian, &l)
-		if err != nil {
-			return err
-		}
-		pkt.Length = TLength(l)
+		err := binary.Read(reader, order, &l)
+		return TLength(l), err
 	}
-	return nil
+	var l uint64
+	err := binary.Read(reader, order, &l)
+	return TLength(l), err
 }
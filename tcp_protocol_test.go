@@ -1,6 +1,7 @@
 package flyrpc
 
 import (
+	"bytes"
 	"log"
 	"net"
 	"testing"
@@ -42,3 +43,24 @@ func TestProtocolReal(t *testing.T) {
 	err = conn1.Close()
 	assert.Nil(t, err)
 }
+
+func TestProtocolMaxLength(t *testing.T) {
+	buf := &bytes.Buffer{}
+	p := newTcpProtocol(buf, buf, false)
+	assert.Nil(t, p.SendPacket(&Packet{Code: "big", Payload: make([]byte, 300)}))
+	assert.Nil(t, p.SendPacket(&Packet{Code: "small", Payload: []byte{1, 2}}))
+	p.MaxLength = func(code string) int {
+		return 10
+	}
+
+	pkt, err := p.ReadPacket()
+	assert.Nil(t, err)
+	assert.Equal(t, "big", pkt.Code)
+	assert.Equal(t, TLength(300), pkt.Length)
+	assert.Nil(t, pkt.Payload)
+
+	pkt, err = p.ReadPacket()
+	assert.Nil(t, err)
+	assert.Equal(t, "small", pkt.Code)
+	assert.Equal(t, []byte{1, 2}, pkt.Payload)
+}
@@ -0,0 +1,129 @@
+package flyrpc
+
+import "sync"
+
+// UnreliableProtocol is implemented by datagram transports (UDP, KCP) which
+// can send a packet without retransmission. Other protocols emulate it by
+// latestQueue.
+//
+// pkt.Seq is the sequence of key. Both must be delivered with the packet and
+// set to Packet.Key and Packet.Seq when read, so the receiver drops packets
+// older than the latest one of key.
+type UnreliableProtocol interface {
+	SendUnreliable(key string, pkt *Packet) error
+}
+
+// keySeqs is the latest sequence of each key sent and received.
+type keySeqs struct {
+	lock     sync.Mutex
+	sent     map[string]TSeq
+	received map[string]TSeq
+}
+
+func newKeySeqs() *keySeqs {
+	return &keySeqs{
+		sent:     make(map[string]TSeq),
+		received: make(map[string]TSeq),
+	}
+}
+
+func (k *keySeqs) next(key string) TSeq {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	seq := (k.sent[key] + 1) & MaxSeq
+	k.sent[key] = seq
+	return seq
+}
+
+// accept returns false if seq is not newer than the latest received of key.
+// Sequences wrap at MaxSeq, the half behind latest is older.
+func (k *keySeqs) accept(key string, seq TSeq) bool {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if last, ok := k.received[key]; ok && int16(uint16(seq)-uint16(last)) <= 0 {
+		return false
+	}
+	k.received[key] = seq
+	return true
+}
+
+// latestQueue send packets one by one, a pending packet is replaced by newer
+// packet of the same key instead of queued.
+type latestQueue struct {
+	lock    sync.Mutex
+	send    func(*Packet) error
+	keys    []string
+	pending map[string]*Packet
+	running bool
+}
+
+func newLatestQueue(send func(*Packet) error) *latestQueue {
+	return &latestQueue{
+		send:    send,
+		pending: make(map[string]*Packet),
+	}
+}
+
+func (q *latestQueue) push(key string, pkt *Packet) {
+	q.lock.Lock()
+	if _, ok := q.pending[key]; !ok {
+		q.keys = append(q.keys, key)
+	}
+	q.pending[key] = pkt
+	if q.running {
+		q.lock.Unlock()
+		return
+	}
+	q.running = true
+	q.lock.Unlock()
+	go q.flush()
+}
+
+func (q *latestQueue) flush() {
+	for {
+		q.lock.Lock()
+		if len(q.keys) == 0 {
+			q.running = false
+			q.lock.Unlock()
+			return
+		}
+		key := q.keys[0]
+		q.keys = q.keys[1:]
+		pkt := q.pending[key]
+		delete(q.pending, key)
+		q.lock.Unlock()
+		// best effort, drop on error
+		q.send(pkt)
+	}
+}
+
+// clear drop all pending packets.
+func (q *latestQueue) clear() {
+	q.lock.Lock()
+	q.keys = nil
+	q.pending = make(map[string]*Packet)
+	q.lock.Unlock()
+}
+
+// SendUnreliable send message without response, only the latest message of
+// key is kept if previous one is not sent yet, and the receiver drops
+// message older than the latest one received. Use it for state updates
+// where only the latest value matters, e.g. position.
+func (ctx *Context) SendUnreliable(key string, code string, message Message) error {
+	payload, err := MessageToBytes(message, ctx.serializer)
+	if err != nil {
+		return err
+	}
+	pkt := &Packet{
+		ClientId: ctx.ClientId,
+		Code:     code,
+		Payload:  payload,
+	}
+	if up, ok := ctx.Protocol.(UnreliableProtocol); ok {
+		pkt.Seq = ctx.keySeqs.next(key)
+		ctx.recordCapture(false, pkt)
+		return up.SendUnreliable(key, pkt)
+	}
+	ctx.latest.push(key, pkt)
+	return nil
+}
@@ -0,0 +1,95 @@
+package flyrpc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockProtocol block SendPacket until gate is closed.
+type blockProtocol struct {
+	*MockProtocol
+	gate chan struct{}
+}
+
+func (bp *blockProtocol) SendPacket(pkt *Packet) error {
+	bp.packetChan <- pkt
+	<-bp.gate
+	return nil
+}
+
+type datagramProtocol struct {
+	*MockProtocol
+	sent []*Packet
+}
+
+func (dp *datagramProtocol) SendUnreliable(key string, pkt *Packet) error {
+	// as read by the peer
+	received := *pkt
+	received.Key = key
+	dp.sent = append(dp.sent, &received)
+	return nil
+}
+
+func TestSendUnreliable(t *testing.T) {
+	protocol := &blockProtocol{NewMockProtocol(), make(chan struct{})}
+	context := NewContext(protocol, NewRouter(JSON), 0, JSON)
+
+	assert.Nil(t, context.SendUnreliable("pos", "move", "1"))
+	pkt, err := protocol.ReadPacket()
+	assert.Nil(t, err)
+	assert.Equal(t, "1", string(pkt.Payload))
+	assert.Equal(t, byte(0), pkt.Flag&FlagWaitResponse)
+
+	// first packet is blocked in sending, following pos are superseded
+	assert.Nil(t, context.SendUnreliable("hp", "hp", "100"))
+	for _, pos := range []string{"2", "3", "4"} {
+		assert.Nil(t, context.SendUnreliable("pos", "move", pos))
+	}
+	close(protocol.gate)
+
+	pkt, err = protocol.ReadPacket()
+	assert.Nil(t, err)
+	assert.Equal(t, "hp", pkt.Code)
+	pkt, err = protocol.ReadPacket()
+	assert.Nil(t, err)
+	assert.Equal(t, "move", pkt.Code)
+	assert.Equal(t, "4", string(pkt.Payload))
+	assert.Equal(t, 0, len(protocol.packetChan))
+}
+
+func TestSendUnreliableDatagram(t *testing.T) {
+	protocol := &datagramProtocol{MockProtocol: NewMockProtocol()}
+	context := NewContext(protocol, NewRouter(JSON), 0, JSON)
+	assert.Nil(t, context.SendUnreliable("pos", "move", "1"))
+	assert.Nil(t, context.SendUnreliable("pos", "move", "2"))
+	assert.Nil(t, context.SendUnreliable("pos", "move", "3"))
+	assert.Nil(t, context.SendUnreliable("hp", "hp", "100"))
+	assert.Equal(t, 4, len(protocol.sent))
+	assert.Equal(t, "pos", protocol.sent[0].Key)
+	assert.Equal(t, TSeq(1), protocol.sent[3].Seq)
+
+	// reordered delivery, stale packets are dropped
+	router := NewRouter(JSON)
+	received := []string{}
+	router.AddRoute("move", func(pos string) {
+		received = append(received, pos)
+	})
+	router.AddRoute("hp", func(hp string) {
+		received = append(received, hp)
+	})
+	peer := NewContext(NewMockProtocol(), router, 0, JSON)
+	for _, i := range []int{0, 2, 1, 3, 2} {
+		peer.emitPacket(protocol.sent[i])
+	}
+	assert.Equal(t, []string{"1", "3", "100"}, received)
+}
+
+func TestKeySeqsWrap(t *testing.T) {
+	k := newKeySeqs()
+	k.sent["pos"] = MaxSeq
+	assert.Equal(t, TSeq(0), k.next("pos"))
+	assert.True(t, k.accept("pos", MaxSeq))
+	assert.True(t, k.accept("pos", 0))
+	assert.Equal(t, false, k.accept("pos", MaxSeq))
+}